@@ -0,0 +1,46 @@
+package main
+
+import (
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+// chirpResponse is a chirp along with its likes and rechirps.
+// LikedByMe is only set for requests made with a bearer token.
+// Moderation flags are for admins only and left out.
+type chirpResponse struct {
+	entities.Chirp
+	LikeCount    int   `json:"like_count"`
+	RechirpCount int   `json:"rechirp_count"`
+	LikedByMe    *bool `json:"liked_by_me,omitempty"`
+}
+
+// chirpResponses adds their stats, as seen by viewerId, to chirps
+func (cfg *apiConfig) chirpResponses(chirps []entities.Chirp, viewerId *int) ([]chirpResponse, error) {
+	ids := make([]int, 0, len(chirps))
+	for _, c := range chirps {
+		ids = append(ids, c.Id)
+	}
+	stats, err := cfg.db.GetChirpStats(ids, viewerId)
+	if err != nil {
+		return nil, err
+	}
+	resp := make([]chirpResponse, 0, len(chirps))
+	for _, c := range chirps {
+		s := stats[c.Id]
+		c.Flags = nil
+		r := chirpResponse{Chirp: c, LikeCount: s.LikeCount, RechirpCount: s.RechirpCount}
+		if viewerId != nil {
+			r.LikedByMe = &s.LikedByMe
+		}
+		resp = append(resp, r)
+	}
+	return resp, nil
+}
+
+func (cfg *apiConfig) chirpResponse(chirp entities.Chirp, viewerId *int) (chirpResponse, error) {
+	resp, err := cfg.chirpResponses([]entities.Chirp{chirp}, viewerId)
+	if err != nil {
+		return chirpResponse{}, err
+	}
+	return resp[0], nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/sp3dr4/chirpy/internal/database"
+	"github.com/sp3dr4/chirpy/internal/keyring"
+)
+
+// keyRetention is how long a replaced signing key still verifies tokens,
+// well beyond the lifetime of the access tokens it signed
+const keyRetention = 24 * time.Hour
+
+const commandsUsage = `commands:
+  backup <file|->   write a compressed snapshot of the database,
+                    which a server may be using meanwhile
+  restore <file|->  replace the database content with a snapshot,
+                    refused while a server uses the same database
+  backup and restore support the json store only
+  rotate-keys [EdDSA|RS256]
+                    add a signing key that replaces the active one, run it
+                    on a schedule then send SIGHUP to the servers; replaced
+                    keys verify tokens for another 24 hours`
+
+// checkSnapshotStore fails unless the store kind supports the backup and
+// restore commands. Only the json store does: the memory store lives in
+// the server process, which takes its snapshots through the admin API.
+func checkSnapshotStore(storeKind string) error {
+	switch storeKind {
+	case "json":
+		return nil
+	case "memory":
+		return errors.New("the memory store does not outlive the command, use the admin API of the server to back it up or restore it")
+	default:
+		return fmt.Errorf("the %s store does not support snapshots, only the json store does", storeKind)
+	}
+}
+
+// runCommand runs the subcommand given on the command line instead of
+// the server: backup only reads the database files, restore takes them
+// over like a server.
+func runCommand(storeKind, keysPath string, args []string) error {
+	if args[0] == "rotate-keys" {
+		return rotateKeys(keysPath, args[1:])
+	}
+	if len(args) != 2 {
+		return errors.New(commandsUsage)
+	}
+	if args[0] != "backup" && args[0] != "restore" {
+		return fmt.Errorf("unknown command %q\n%s", args[0], commandsUsage)
+	}
+	if err := checkSnapshotStore(storeKind); err != nil {
+		return err
+	}
+	if args[0] == "backup" {
+		return backup(args[1])
+	}
+	return restore(args[1])
+}
+
+// backup writes a snapshot of the json database to the file at path,
+// which must not exist, or to stdout for "-"
+func backup(path string) error {
+	db, err := database.ReadDB(jsonDatabasePath)
+	if err != nil {
+		return err
+	}
+	var out io.Writer = os.Stdout
+	if path != "-" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	return db.Backup(out)
+}
+
+// restore replaces the content of the json database with the snapshot
+// in the file at path, or on stdin for "-"
+func restore(path string) error {
+	db, err := database.NewDB(jsonDatabasePath, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	if err := db.Restore(in); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "restored %s\n", path)
+	return nil
+}
+
+// rotateKeys makes a new key of the given algorithm, EdDSA by default,
+// the active key of the file at keysPath
+func rotateKeys(keysPath string, args []string) error {
+	alg := keyring.EdDSA
+	switch len(args) {
+	case 0:
+	case 1:
+		alg = keyring.Algorithm(args[0])
+	default:
+		return errors.New(commandsUsage)
+	}
+	key, err := keyring.Rotate(keysPath, alg, keyRetention)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "active signing key %s (%s)\n", key.Id, key.Algorithm)
+	return nil
+}
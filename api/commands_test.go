@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotCommandsNeedTheJSONStore(t *testing.T) {
+	// the commands work on the database files of the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	tests := []struct {
+		store string
+		want  string
+	}{
+		{"memory", "memory store does not outlive the command"},
+		{"sqlite", "sqlite store does not support snapshots"},
+	}
+	for _, tt := range tests {
+		for _, command := range []string{"backup", "restore"} {
+			err := runCommand(tt.store, "", []string{command, "snapshot.gz"})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("%s with the %s store: got %v, want %q", command, tt.store, err, tt.want)
+			}
+		}
+	}
+	if entries, _ := os.ReadDir("."); len(entries) != 0 {
+		t.Errorf("the commands created %d files", len(entries))
+	}
+}
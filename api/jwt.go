@@ -3,11 +3,15 @@ package main
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"strconv"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/sp3dr4/chirpy/internal/entities"
+	"github.com/sp3dr4/chirpy/internal/keyring"
 )
 
 const defaultJwtExpirationSeconds int = 60 * 60
@@ -17,40 +21,103 @@ func buildExpiration(expirationSeconds int) time.Time {
 	return time.Now().Add(time.Duration(expirationSeconds) * time.Second)
 }
 
-func createJwt(userId int, secret string) (string, error) {
+// signingMethods are the only algorithms tokens may be signed with
+var signingMethods = map[keyring.Algorithm]jwt.SigningMethod{
+	keyring.RS256: jwt.SigningMethodRS256,
+	keyring.EdDSA: jwt.SigningMethodEdDSA,
+}
+
+const jwtIssuer = "chirpy"
+const jwtAudience = "chirpy-api"
+
+// accessScope is the scope of the access tokens issued at login,
+// the whole API on behalf of the user
+const accessScope = "api"
+
+type accessClaims struct {
+	jwt.RegisteredClaims
+	// Scope is the space separated list of scopes granted to the token
+	Scope     string `json:"scope"`
+	ChirpyRed bool   `json:"chirpy_red"`
+}
+
+func (c accessClaims) hasScope(scope string) bool {
+	return slices.Contains(strings.Fields(c.Scope), scope)
+}
+
+// newAccessToken picks the jti and the expiration of a new access token,
+// which sessions keep to revoke it
+func newAccessToken() (entities.AccessToken, error) {
+	id, err := buildRandomToken()
+	if err != nil {
+		return entities.AccessToken{}, err
+	}
+	expiresAt := buildExpiration(defaultJwtExpirationSeconds).UTC().Truncate(time.Second)
+	return entities.AccessToken{Id: id, ExpiresAt: expiresAt}, nil
+}
+
+// createJwt signs the access token of user with the active key of keys,
+// naming the key in the kid header so verifiers can look it up
+func createJwt(user entities.User, accessToken entities.AccessToken, keys *keyring.Keyring) (string, error) {
 	now := time.Now()
-	claims := jwt.RegisteredClaims{
-		Issuer:    "chirpy",
-		IssuedAt:  jwt.NewNumericDate(now),
-		ExpiresAt: jwt.NewNumericDate(buildExpiration(defaultJwtExpirationSeconds)),
-		Subject:   fmt.Sprint(userId),
+	claims := accessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        accessToken.Id,
+			Issuer:    jwtIssuer,
+			Audience:  jwt.ClaimStrings{jwtAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(accessToken.ExpiresAt),
+			Subject:   fmt.Sprint(user.Id),
+		},
+		Scope:     accessScope,
+		ChirpyRed: user.IsChirpyRed,
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signed, err := token.SignedString([]byte(secret))
+	key := keys.Active()
+	token := jwt.NewWithClaims(signingMethods[key.Algorithm], claims)
+	token.Header["kid"] = key.Id
+	signed, err := token.SignedString(key.Signer())
 	if err != nil {
 		return "", err
 	}
 	return signed, nil
 }
 
-func getUserIdFromJwt(value, secret string) (int, error) {
-	token, err := jwt.ParseWithClaims(
+// parseJwt verifies the token with the key of keys named by its kid header,
+// which must have been made for the algorithm the token names, then checks
+// its issuer, audience and validity period
+func parseJwt(value string, keys *keyring.Keyring) (*accessClaims, error) {
+	claims := &accessClaims{}
+	_, err := jwt.ParseWithClaims(
 		value,
-		&jwt.RegisteredClaims{},
-		func(t *jwt.Token) (interface{}, error) { return []byte(secret), nil },
+		claims,
+		func(t *jwt.Token) (interface{}, error) {
+			kid, _ := t.Header["kid"].(string)
+			key, ok := keys.Lookup(kid)
+			if !ok {
+				return nil, errors.New("unknown signing key")
+			}
+			if t.Method.Alg() != string(key.Algorithm) {
+				return nil, errors.New("signing algorithm does not match the key")
+			}
+			return key.Public(), nil
+		},
+		jwt.WithValidMethods([]string{string(keyring.RS256), string(keyring.EdDSA)}),
+		jwt.WithIssuer(jwtIssuer),
+		jwt.WithAudience(jwtAudience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
 	)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	userIdStr, err := token.Claims.GetSubject()
-	if err != nil {
-		return 0, err
+	if claims.NotBefore == nil {
+		return nil, errors.New("token has no nbf claim")
 	}
-	userId, err := strconv.Atoi(userIdStr)
-	if err != nil {
-		return 0, err
+	if claims.ID == "" {
+		return nil, errors.New("token has no jti claim")
 	}
-	return userId, nil
+	return claims, nil
 }
 
 func buildRandomToken() (string, error) {
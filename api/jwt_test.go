@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sp3dr4/chirpy/internal/database"
+	"github.com/sp3dr4/chirpy/internal/entities"
+	"github.com/sp3dr4/chirpy/internal/keyring"
+)
+
+func newTestKeyring(t *testing.T) *keyring.Keyring {
+	t.Helper()
+	keys, err := keyring.Open(filepath.Join(t.TempDir(), "jwt_keys.json"), keyRetention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// signClaims signs claims with the active key of keys, as createJwt does
+func signClaims(t *testing.T, keys *keyring.Keyring, claims accessClaims) string {
+	t.Helper()
+	key := keys.Active()
+	token := jwt.NewWithClaims(signingMethods[key.Algorithm], claims)
+	token.Header["kid"] = key.Id
+	signed, err := token.SignedString(key.Signer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func validClaims() accessClaims {
+	now := time.Now()
+	return accessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "jti",
+			Issuer:    jwtIssuer,
+			Audience:  jwt.ClaimStrings{jwtAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+			Subject:   "1",
+		},
+		Scope: accessScope,
+	}
+}
+
+func TestCreateJwtClaims(t *testing.T) {
+	keys := newTestKeyring(t)
+	accessToken, err := newAccessToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := createJwt(entities.User{Id: 7, IsChirpyRed: true}, accessToken, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := parseJwt(signed, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.ID != accessToken.Id || claims.Subject != "7" {
+		t.Errorf("got jti %q and sub %q", claims.ID, claims.Subject)
+	}
+	if !claims.hasScope(accessScope) || !claims.ChirpyRed {
+		t.Errorf("got scope %q and chirpy_red %v", claims.Scope, claims.ChirpyRed)
+	}
+}
+
+func TestParseJwtRejects(t *testing.T) {
+	keys := newTestKeyring(t)
+	if _, err := parseJwt(signClaims(t, keys, validClaims()), keys); err != nil {
+		t.Fatalf("valid claims: %v", err)
+	}
+
+	tests := map[string]func(c *accessClaims){
+		"wrong audience": func(c *accessClaims) { c.Audience = jwt.ClaimStrings{"other"} },
+		"wrong issuer":   func(c *accessClaims) { c.Issuer = "other" },
+		"no nbf":         func(c *accessClaims) { c.NotBefore = nil },
+		"future nbf":     func(c *accessClaims) { c.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Hour)) },
+		"no jti":         func(c *accessClaims) { c.ID = "" },
+		"no exp":         func(c *accessClaims) { c.ExpiresAt = nil },
+		"expired":        func(c *accessClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute)) },
+	}
+	for name, change := range tests {
+		t.Run(name, func(t *testing.T) {
+			claims := validClaims()
+			change(&claims)
+			if _, err := parseJwt(signClaims(t, keys, claims), keys); err == nil {
+				t.Fatal("token accepted")
+			}
+		})
+	}
+
+	t.Run("HS256", func(t *testing.T) {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims())
+		token.Header["kid"] = keys.Active().Id
+		signed, err := token.SignedString([]byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parseJwt(signed, keys); err == nil {
+			t.Fatal("token accepted")
+		}
+	})
+
+	t.Run("unknown kid", func(t *testing.T) {
+		if _, err := parseJwt(signClaims(t, newTestKeyring(t), validClaims()), keys); err == nil {
+			t.Fatal("token accepted")
+		}
+	})
+}
+
+func TestIsAuthenticatedRejectsRevokedTokens(t *testing.T) {
+	db := database.NewMemoryDB()
+	cfg := &apiConfig{db: db, keys: newTestKeyring(t)}
+	user, err := db.CreateUser("a@example.com", "hash", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessToken, err := newAccessToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := db.CreateSession(entities.Session{
+		UserId:       user.Id,
+		TokenHash:    "hash",
+		ExpiresAt:    time.Now().Add(time.Hour),
+		AccessTokens: []entities.AccessToken{accessToken},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := createJwt(*user, accessToken, cfg.keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/api/chirps", nil)
+	req.Header.Set("Authorization", "Bearer "+signed)
+
+	if id, err := cfg.isAuthenticated(req); err != nil || id != user.Id {
+		t.Fatalf("before logout: got %d and %v", id, err)
+	}
+	if err := db.DeleteSession(user.Id, session.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.isAuthenticated(req); err == nil {
+		t.Fatal("revoked token accepted")
+	}
+}
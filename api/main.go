@@ -3,20 +3,34 @@ package main
 import (
 	"encoding/json"
 	"flag"
+	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 
 	"github.com/joho/godotenv"
 	"github.com/sp3dr4/chirpy/internal/database"
+	"github.com/sp3dr4/chirpy/internal/fixtures"
+	"github.com/sp3dr4/chirpy/internal/keyring"
+	"github.com/sp3dr4/chirpy/internal/moderation"
 )
 
 type apiConfig struct {
 	fileserverHits int
-	jwtSecret      string
 	polkaApiKey    string
-	db             *database.DB
+	adminApiKey    string
+	db             database.Store
+	moderator      *moderation.Moderator
+	keys           *keyring.Keyring
+
+	// refreshTokenKey keys the hashes of the refresh tokens, see hashToken
+	refreshTokenKey []byte
 }
 
 func respondWithError(w http.ResponseWriter, code int, msg string) {
@@ -46,39 +60,178 @@ func handlerHealth(w http.ResponseWriter, req *http.Request) {
 	io.WriteString(w, "OK")
 }
 
+const jsonDatabasePath = "database.json"
+
+func openStore(kind string, reset bool) (database.Store, error) {
+	switch kind {
+	case "json":
+		return database.NewDB(jsonDatabasePath, reset)
+	case "sqlite":
+		return database.NewSQLiteDB("database.sqlite", reset)
+	case "memory":
+		return database.NewMemoryDB(), nil
+	default:
+		return nil, fmt.Errorf("unknown store %q", kind)
+	}
+}
+
+// publicFiles are the web app files under the working directory, the only
+// ones served so that the database and configuration files stay private
+type publicFiles struct {
+	root http.Dir
+}
+
+func (p publicFiles) Open(name string) (http.File, error) {
+	if name != "/" && name != "/index.html" && !strings.HasPrefix(name, "/assets/") {
+		return nil, fs.ErrNotExist
+	}
+	return p.root.Open(name)
+}
+
+// reloadOnHangup reloads the moderation rules and the signing keys
+// whenever the process gets SIGHUP
+func reloadOnHangup(moderator *moderation.Moderator, keys *keyring.Keyring) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			if err := moderator.Reload(); err != nil {
+				log.Printf("moderation rules not reloaded: %s", err)
+			} else {
+				log.Print("moderation rules reloaded")
+			}
+			if err := keys.Reload(); err != nil {
+				log.Printf("signing keys not reloaded: %s", err)
+			} else {
+				log.Printf("signing keys reloaded, active key %s", keys.Active().Id)
+			}
+		}
+	}()
+}
+
 func main() {
 	godotenv.Load()
-	jwtSecret := os.Getenv("JWT_SECRET")
+	refreshTokenKey := os.Getenv("REFRESH_TOKEN_KEY")
+	if refreshTokenKey == "" {
+		log.Fatal("REFRESH_TOKEN_KEY must be set to key the stored refresh token hashes")
+	}
 	polkaApiKey := os.Getenv("POLKA_WEBHOOK_API_KEY")
+	adminApiKey := os.Getenv("ADMIN_API_KEY")
 
-	isDebug := flag.Bool("debug", false, "Enable debug mode")
+	reset := flag.Bool("reset", false, "Discard all data in the database on startup")
+	seed := flag.String("seed", "", "Fixture file (YAML or JSON) to load on startup")
+	env := flag.String("env", "", "Named environment: same as -reset -seed fixtures/<env>.yaml")
+	storeKind := flag.String("store", "json", "Storage backend: json, sqlite or memory")
+	migrateDryRun := flag.Bool("migrate-dry-run", false, "Print the pending database.json migrations and exit")
+	moderationRules := flag.String("moderation-rules", "moderation.yaml", "Moderation rules file, reloaded on SIGHUP; built-in rules are used when missing")
+	jwtKeys := flag.String("jwt-keys", "jwt_keys.json", "Access token signing keys file, reloaded on SIGHUP; created with an EdDSA key when missing")
 	flag.Parse()
 
-	db, err := database.NewDB("database.json", *isDebug)
+	if *migrateDryRun {
+		result, err := database.Migrate(jsonDatabasePath, true)
+		if err != nil {
+			log.Fatalf("error checking migrations: %s", err)
+		}
+		fmt.Printf("schema version %d, latest %d\n", result.From, result.To)
+		for _, m := range result.Applied {
+			fmt.Printf("pending migration %s\n", m)
+		}
+		return
+	}
+
+	// commands open the database themselves, backup must not write it
+	// and rotating keys does not touch it
+	if flag.NArg() > 0 {
+		if err := runCommand(*storeKind, *jwtKeys, flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *env != "" {
+		*reset = true
+		*seed = filepath.Join("fixtures", *env+".yaml")
+	}
+	db, err := openStore(*storeKind, *reset)
 	if err != nil {
 		log.Fatalf("error with database initialization: %s", err)
 	}
+	moderator, err := moderation.NewModerator(*moderationRules)
+	if err != nil {
+		log.Fatalf("error loading moderation rules: %s", err)
+	}
+	if *seed != "" {
+		f, err := fixtures.Load(*seed)
+		if err != nil {
+			log.Fatalf("error loading fixtures: %s", err)
+		}
+		if err := f.Apply(db, moderator); err != nil {
+			log.Fatalf("error applying fixtures: %s", err)
+		}
+	}
+	keys, err := keyring.Open(*jwtKeys, keyRetention)
+	if err != nil {
+		log.Fatalf("error loading signing keys: %s", err)
+	}
+	reloadOnHangup(moderator, keys)
+
 	cfg := apiConfig{
-		fileserverHits: 0,
-		jwtSecret:      jwtSecret,
-		polkaApiKey:    polkaApiKey,
-		db:             db,
+		fileserverHits:  0,
+		refreshTokenKey: []byte(refreshTokenKey),
+		polkaApiKey:     polkaApiKey,
+		adminApiKey:     adminApiKey,
+		db:              db,
+		moderator:       moderator,
+		keys:            keys,
 	}
 	mux := http.NewServeMux()
-	fileSv := http.FileServer(http.Dir("."))
-	mux.Handle("/app/*", http.StripPrefix("/app", cfg.middlewareMetricsInc(fileSv)))
+	fileSv := http.FileServer(publicFiles{http.Dir(".")})
+	mux.Handle("/app/", http.StripPrefix("/app", cfg.middlewareMetricsInc(fileSv)))
 	mux.HandleFunc("/api/reset", cfg.handlerResetMetrics)
 	mux.HandleFunc("GET /admin/metrics", cfg.handlerGetMetrics)
+	mux.HandleFunc("GET /admin/snapshot", cfg.handlerSnapshot)
+	mux.HandleFunc("POST /admin/restore", cfg.handlerRestore)
+	mux.HandleFunc("POST /admin/moderation/reload", cfg.handlerReloadModeration)
+	mux.HandleFunc("GET /api/admin/reports", cfg.handlerListReports)
+	mux.HandleFunc("POST /api/admin/reports/{reportId}/resolve", cfg.handlerResolveReport)
 	mux.HandleFunc("GET /api/healthz", handlerHealth)
+	mux.HandleFunc("GET /.well-known/jwks.json", cfg.handlerJwks)
 	mux.HandleFunc("POST /api/chirps", cfg.handlerCreateChirp)
 	mux.HandleFunc("GET /api/chirps", cfg.handlerListChirps)
+	mux.HandleFunc("GET /api/chirps/search", cfg.handlerSearchChirps)
 	mux.HandleFunc("GET /api/chirps/{chirpId}", cfg.handlerGetChirp)
+	mux.HandleFunc("PUT /api/chirps/{chirpId}", cfg.handlerUpdateChirp)
 	mux.HandleFunc("DELETE /api/chirps/{chirpId}", cfg.handlerDeleteChirp)
+	mux.HandleFunc("GET /api/chirps/{chirpId}/history", cfg.handlerChirpHistory)
+	mux.HandleFunc("GET /api/chirps/{chirpId}/thread", cfg.handlerChirpThread)
+	mux.HandleFunc("POST /api/chirps/{chirpId}/like", cfg.handlerLike)
+	mux.HandleFunc("DELETE /api/chirps/{chirpId}/like", cfg.handlerUnlike)
+	mux.HandleFunc("POST /api/chirps/{chirpId}/rechirp", cfg.handlerRechirp)
+	mux.HandleFunc("DELETE /api/chirps/{chirpId}/rechirp", cfg.handlerUnrechirp)
+	mux.HandleFunc("GET /api/hashtags/trending", cfg.handlerTrendingHashtags)
+	mux.HandleFunc("GET /api/hashtags/{tag}/chirps", cfg.handlerHashtagChirps)
 	mux.HandleFunc("POST /api/users", cfg.handlerCreateUser)
 	mux.HandleFunc("PUT /api/users", cfg.handlerUpdateUser)
+	mux.HandleFunc("POST /api/users/{userId}/follow", cfg.handlerFollow)
+	mux.HandleFunc("DELETE /api/users/{userId}/follow", cfg.handlerUnfollow)
+	mux.HandleFunc("GET /api/users/{userId}/followers", cfg.handlerListFollowers)
+	mux.HandleFunc("GET /api/users/{userId}/following", cfg.handlerListFollowing)
+	mux.HandleFunc("POST /api/users/{userId}/block", cfg.handlerBlock)
+	mux.HandleFunc("DELETE /api/users/{userId}/block", cfg.handlerUnblock)
+	mux.HandleFunc("POST /api/users/{userId}/mute", cfg.handlerMute)
+	mux.HandleFunc("DELETE /api/users/{userId}/mute", cfg.handlerUnmute)
+	mux.HandleFunc("GET /api/blocks", cfg.handlerListBlocked)
+	mux.HandleFunc("GET /api/mutes", cfg.handlerListMuted)
+	mux.HandleFunc("GET /api/timeline", cfg.handlerTimeline)
+	mux.HandleFunc("GET /api/inbox", cfg.handlerInbox)
+	mux.HandleFunc("POST /api/reports", cfg.handlerCreateReport)
 	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+	mux.HandleFunc("GET /api/sessions", cfg.handlerListSessions)
+	mux.HandleFunc("DELETE /api/sessions", cfg.handlerDeleteSessions)
+	mux.HandleFunc("DELETE /api/sessions/{sessionId}", cfg.handlerDeleteSession)
+	mux.HandleFunc("GET /api/security-events", cfg.handlerListSecurityEvents)
 	mux.HandleFunc("POST /api/polka/webhooks", cfg.handlerWebhookPolka)
 	server := &http.Server{
 		Addr:    ":8080",
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPublicFilesServesOnlyTheApp(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"index.html", "assets/logo.png", "database.json", ".env", "jwt_keys.json"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := http.StripPrefix("/app", http.FileServer(publicFiles{http.Dir(dir)}))
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/app/", 200},
+		{"/app/assets/logo.png", 200},
+		{"/app/database.json", 404},
+		{"/app/.env", 404},
+		{"/app/jwt_keys.json", 404},
+		{"/app/assets/../database.json", 404},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		// the file server redirects cleaned paths, follow them by hand
+		if w.Code == http.StatusMovedPermanently {
+			w2 := httptest.NewRecorder()
+			handler.ServeHTTP(w2, httptest.NewRequest("GET", w.Header().Get("Location"), nil))
+			w = w2
+		}
+		if w.Code != tt.code {
+			t.Errorf("GET %s: got %d, want %d", tt.path, w.Code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/sp3dr4/chirpy/internal/database"
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+const defaultPageLimit = 20
+const maxPageLimit = 100
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// pageCursor is the opaque position a page continues from. It carries the
+// listing options so it cannot be replayed against a different listing.
+type pageCursor struct {
+	AfterId  int    `json:"after"`
+	Desc     bool   `json:"desc,omitempty"`
+	AuthorId *int   `json:"author,omitempty"`
+	Hashtag  string `json:"tag,omitempty"`
+	Inbox    bool   `json:"inbox,omitempty"`
+}
+
+func (c pageCursor) encode() string {
+	dat, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(dat)
+}
+
+func decodeCursor(value string) (pageCursor, error) {
+	c := pageCursor{}
+	dat, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return c, errInvalidCursor
+	}
+	if err := json.Unmarshal(dat, &c); err != nil || c.AfterId <= 0 {
+		return c, errInvalidCursor
+	}
+	return c, nil
+}
+
+func (c pageCursor) matches(q database.ChirpQuery) bool {
+	if c.Desc != q.Desc || c.Hashtag != q.Hashtag || c.Inbox != q.Inbox || (c.AuthorId == nil) != (q.AuthorId == nil) {
+		return false
+	}
+	return q.AuthorId == nil || *c.AuthorId == *q.AuthorId
+}
+
+func parseLimit(query url.Values) (int, error) {
+	value := query.Get("limit")
+	if value == "" {
+		return defaultPageLimit, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > maxPageLimit {
+		return 0, fmt.Errorf("limit must be an integer between 1 and %d", maxPageLimit)
+	}
+	return limit, nil
+}
+
+// setNextLink advertises the next page in a Link header,
+// keeping every query parameter of the current request
+func setNextLink(w http.ResponseWriter, req *http.Request, nextCursor string) {
+	query := req.URL.Query()
+	query.Set("cursor", nextCursor)
+	next := url.URL{Path: req.URL.Path, RawQuery: query.Encode()}
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.String()))
+}
+
+// respondWithChirpPage lists the page of chirps selected by q and the
+// limit and cursor query parameters, q.Desc and the filters of q must
+// already be set from the request
+func (cfg *apiConfig) respondWithChirpPage(w http.ResponseWriter, req *http.Request, q database.ChirpQuery) {
+	type response struct {
+		Chirps     []chirpResponse `json:"chirps"`
+		NextCursor string          `json:"next_cursor,omitempty"`
+	}
+	query := req.URL.Query()
+
+	viewerId, err := cfg.viewer(req)
+	if err != nil {
+		respondWithError(w, 401, err.Error())
+		return
+	}
+
+	limit, err := parseLimit(query)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	q.Limit = limit
+	q.ViewerId = viewerId
+
+	if cursorQuery := query.Get("cursor"); cursorQuery != "" {
+		cursor, err := decodeCursor(cursorQuery)
+		if err != nil {
+			respondWithError(w, 400, err.Error())
+			return
+		}
+		if !cursor.matches(q) {
+			respondWithError(w, 400, "cursor does not match the listing parameters")
+			return
+		}
+		q.AfterId = cursor.AfterId
+	}
+
+	chirps, more, err := cfg.db.ListChirps(q)
+	if err != nil {
+		respondWithError(w, 500, err.Error())
+		return
+	}
+
+	resp := response{}
+	if resp.Chirps, err = cfg.chirpResponses(chirps, viewerId); err != nil {
+		respondWithError(w, 500, err.Error())
+		return
+	}
+	if more {
+		last := chirps[len(chirps)-1].Id
+		resp.NextCursor = pageCursor{AfterId: last, Desc: q.Desc, AuthorId: q.AuthorId, Hashtag: q.Hashtag, Inbox: q.Inbox}.encode()
+		setNextLink(w, req, resp.NextCursor)
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+// respondWithAllChirps lists every chirp selected by q as a bare array,
+// reading them page by page, q.Desc and the filters of q must already be
+// set from the request
+func (cfg *apiConfig) respondWithAllChirps(w http.ResponseWriter, req *http.Request, q database.ChirpQuery) {
+	viewerId, err := cfg.viewer(req)
+	if err != nil {
+		respondWithError(w, 401, err.Error())
+		return
+	}
+	q.Limit = maxPageLimit
+	q.ViewerId = viewerId
+
+	all := []entities.Chirp{}
+	for {
+		chirps, more, err := cfg.db.ListChirps(q)
+		if err != nil {
+			respondWithError(w, 500, err.Error())
+			return
+		}
+		all = append(all, chirps...)
+		if !more {
+			break
+		}
+		q.AfterId = chirps[len(chirps)-1].Id
+	}
+
+	resp, err := cfg.chirpResponses(all, viewerId)
+	if err != nil {
+		respondWithError(w, 500, err.Error())
+		return
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+// parseSort reads the sort query parameter, asc or desc, and reports
+// whether it is descending
+func parseSort(query url.Values) (bool, error) {
+	switch query.Get("sort") {
+	case "", "asc":
+		return false, nil
+	case "desc":
+		return true, nil
+	}
+	return false, errors.New("invalid sort query parameter")
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sp3dr4/chirpy/internal/database"
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+func TestListChirpsShapes(t *testing.T) {
+	db := database.NewMemoryDB()
+	user, err := db.CreateUser("a@example.com", "hash", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// more than a page of the largest size
+	total := maxPageLimit + 5
+	for i := range total {
+		if _, err := db.CreateChirp(entities.Chirp{UserId: user.Id, Body: fmt.Sprintf("chirp %d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &apiConfig{db: db}
+
+	w := httptest.NewRecorder()
+	cfg.handlerListChirps(w, httptest.NewRequest("GET", "/api/chirps", nil))
+	var all []chirpResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &all); err != nil {
+		t.Fatalf("without pagination parameters: %v, body %s", err, w.Body)
+	}
+	if len(all) != total {
+		t.Fatalf("got %d chirps, want all %d", len(all), total)
+	}
+
+	w = httptest.NewRecorder()
+	cfg.handlerListChirps(w, httptest.NewRequest("GET", "/api/chirps?limit=10", nil))
+	var page struct {
+		Chirps     []chirpResponse `json:"chirps"`
+		NextCursor string          `json:"next_cursor"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("with limit: %v, body %s", err, w.Body)
+	}
+	if len(page.Chirps) != 10 || page.NextCursor == "" {
+		t.Fatalf("got %d chirps and cursor %q, want a page of 10 and a cursor", len(page.Chirps), page.NextCursor)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sp3dr4/chirpy/internal/database"
+)
+
+func (cfg *apiConfig) isAdmin(r *http.Request) bool {
+	apiKey, found := strings.CutPrefix(r.Header.Get("Authorization"), "ApiKey ")
+	return found && cfg.adminApiKey != "" && apiKey == cfg.adminApiKey
+}
+
+func (cfg *apiConfig) handlerReloadModeration(w http.ResponseWriter, req *http.Request) {
+	if !cfg.isAdmin(req) {
+		respondWithError(w, 401, "unauthorized")
+		return
+	}
+	if err := cfg.moderator.Reload(); err != nil {
+		respondWithError(w, 400, fmt.Sprintf("moderation rules not reloaded: %s", err))
+		return
+	}
+	respondWithJSON(w, 204, struct{}{})
+}
+
+func (cfg *apiConfig) handlerSnapshot(w http.ResponseWriter, req *http.Request) {
+	if !cfg.isAdmin(req) {
+		respondWithError(w, 401, "unauthorized")
+		return
+	}
+	backupper, ok := cfg.db.(database.Backupper)
+	if !ok {
+		respondWithError(w, 501, "snapshots are not supported by this store")
+		return
+	}
+	filename := fmt.Sprintf("chirpy-%s.json.gz", time.Now().UTC().Format("20060102T150405Z"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(200)
+	// headers are already sent, a failure can only truncate the download,
+	// which the checksum catches on restore
+	backupper.Backup(w)
+}
+
+func (cfg *apiConfig) handlerRestore(w http.ResponseWriter, req *http.Request) {
+	if !cfg.isAdmin(req) {
+		respondWithError(w, 401, "unauthorized")
+		return
+	}
+	backupper, ok := cfg.db.(database.Backupper)
+	if !ok {
+		respondWithError(w, 501, "snapshots are not supported by this store")
+		return
+	}
+	if err := backupper.Restore(req.Body); err != nil {
+		if errors.Is(err, database.ErrInvalidSnapshot) {
+			respondWithError(w, 400, err.Error())
+		} else {
+			respondWithError(w, 500, err.Error())
+		}
+		return
+	}
+	respondWithJSON(w, 204, struct{}{})
+}
@@ -4,10 +4,10 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
-	"slices"
+	"strconv"
 	"strings"
-	"time"
 
+	"github.com/sp3dr4/chirpy/internal/database"
 	"github.com/sp3dr4/chirpy/internal/entities"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -18,13 +18,42 @@ func (cfg *apiConfig) isAuthenticated(r *http.Request) (int, error) {
 		return 0, errors.New("no authorization header")
 	}
 
-	userId, err := getUserIdFromJwt(tokenStr, cfg.jwtSecret)
+	claims, err := parseJwt(tokenStr, cfg.keys)
+	if err != nil || !claims.hasScope(accessScope) {
+		return 0, errors.New("unauthorized")
+	}
+	userId, err := strconv.Atoi(claims.Subject)
 	if err != nil {
 		return 0, errors.New("unauthorized")
 	}
+	if revoked, err := cfg.db.IsTokenRevoked(claims.ID); err != nil || revoked {
+		return 0, errors.New("unauthorized")
+	}
+	user, err := cfg.db.GetUserByID(userId)
+	if err != nil || user.Suspended {
+		return 0, errors.New("unauthorized")
+	}
 	return userId, nil
 }
 
+// hashToken returns the keyed hash sessions keep of a refresh token
+func (cfg *apiConfig) hashToken(token string) string {
+	return entities.HashToken(cfg.refreshTokenKey, token)
+}
+
+// viewer returns the id of the user making the request, or nil when it
+// carries no bearer token. A bearer token that does not verify is an error.
+func (cfg *apiConfig) viewer(r *http.Request) (*int, error) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		return nil, nil
+	}
+	userId, err := cfg.isAuthenticated(r)
+	if err != nil {
+		return nil, err
+	}
+	return &userId, nil
+}
+
 func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, req *http.Request) {
 	type loginResponse struct {
 		userResponse
@@ -37,25 +66,30 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	users, err := cfg.db.GetUsers()
+	user, err := cfg.db.GetUserByEmail(strings.ToLower(userReq.Email))
 	if err != nil {
-		respondWithError(w, 500, err.Error())
-		return
-	}
-	i := slices.IndexFunc(users, func(c entities.User) bool {
-		return c.Email == strings.ToLower(userReq.Email)
-	})
-	if i == -1 {
-		respondWithError(w, 404, "user not found")
+		if errors.Is(err, database.ErrUserNotFound) {
+			respondWithError(w, 404, "user not found")
+		} else {
+			respondWithError(w, 500, err.Error())
+		}
 		return
 	}
-	user := users[i]
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(userReq.Password)); err != nil {
 		respondWithError(w, 401, "unauthorized")
 		return
 	}
+	if user.Suspended {
+		respondWithError(w, 403, "account suspended")
+		return
+	}
 
-	signedToken, err := createJwt(user.Id, cfg.jwtSecret)
+	accessToken, err := newAccessToken()
+	if err != nil {
+		respondWithError(w, 500, err.Error())
+		return
+	}
+	signedToken, err := createJwt(*user, accessToken, cfg.keys)
 	if err != nil {
 		respondWithError(w, 500, err.Error())
 		return
@@ -65,7 +99,14 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, req *http.Request) {
 		respondWithError(w, 500, err.Error())
 		return
 	}
-	refreshToken, err := cfg.db.SaveRefreshToken(user.Id, refreshStr, buildExpiration(defaultRefreshExpirationSeconds))
+	_, err = cfg.db.CreateSession(entities.Session{
+		UserId:       user.Id,
+		TokenHash:    cfg.hashToken(refreshStr),
+		UserAgent:    req.UserAgent(),
+		IP:           clientIP(req),
+		ExpiresAt:    buildExpiration(defaultRefreshExpirationSeconds),
+		AccessTokens: []entities.AccessToken{accessToken},
+	})
 	if err != nil {
 		respondWithError(w, 500, err.Error())
 		return
@@ -75,31 +116,53 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, req *http.Request) {
 		w,
 		200,
 		loginResponse{
-			userResponse: userResponse{Id: user.Id, Email: user.Email, IsChirpyRed: user.IsChirpyRed},
+			userResponse: newUserResponse(*user),
 			Token:        signedToken,
-			RefreshToken: refreshToken.Token,
+			RefreshToken: refreshStr,
 		},
 	)
 }
 
+// handlerRefresh issues a new access token along with a new refresh token,
+// the one presented cannot be used again
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, req *http.Request) {
 	refreshStr, found := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
 	if !found {
 		respondWithError(w, 401, "no authorization header")
 		return
 	}
-
-	refreshObj, err := cfg.db.GetRefreshToken(refreshStr)
+	newRefreshStr, err := buildRandomToken()
 	if err != nil {
-		respondWithError(w, 401, err.Error())
+		respondWithError(w, 500, err.Error())
 		return
 	}
-	if refreshObj.ExpiresAt.Before(time.Now()) {
-		respondWithError(w, 401, "token expired")
+	accessToken, err := newAccessToken()
+	if err != nil {
+		respondWithError(w, 500, err.Error())
 		return
 	}
 
-	signedToken, err := createJwt(refreshObj.UserId, cfg.jwtSecret)
+	session, err := cfg.db.RotateSession(cfg.hashToken(refreshStr), cfg.hashToken(newRefreshStr), accessToken, clientIP(req), req.UserAgent())
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrSessionNotFound):
+			respondWithError(w, 401, "refresh token not found")
+		case errors.Is(err, database.ErrSessionExpired):
+			respondWithError(w, 401, "token expired")
+		case errors.Is(err, database.ErrTokenReused):
+			respondWithError(w, 401, err.Error())
+		default:
+			respondWithError(w, 500, err.Error())
+		}
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(session.UserId)
+	if err != nil {
+		respondWithError(w, 500, err.Error())
+		return
+	}
+	signedToken, err := createJwt(*user, accessToken, cfg.keys)
 	if err != nil {
 		respondWithError(w, 500, err.Error())
 		return
@@ -109,8 +172,9 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, req *http.Request) {
 		w,
 		200,
 		struct {
-			Token string `json:"token"`
-		}{Token: signedToken},
+			Token        string `json:"token"`
+			RefreshToken string `json:"refresh_token"`
+		}{Token: signedToken, RefreshToken: newRefreshStr},
 	)
 }
 
@@ -118,11 +182,23 @@ func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, req *http.Request) {
 	refreshStr, found := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
 	if !found {
 		respondWithError(w, 401, "no authorization header")
+		return
 	}
 
-	if err := cfg.db.DeleteRefreshToken(refreshStr); err != nil {
-		respondWithError(w, 401, err.Error())
+	if err := cfg.db.DeleteSessionByToken(cfg.hashToken(refreshStr)); err != nil {
+		if errors.Is(err, database.ErrSessionNotFound) {
+			respondWithError(w, 401, "refresh token not found")
+		} else {
+			respondWithError(w, 500, err.Error())
+		}
 		return
 	}
 	respondWithJSON(w, 204, struct{}{})
 }
+
+// handlerJwks publishes the public keys verifying the access tokens,
+// for other services to check them without sharing a secret
+func (cfg *apiConfig) handlerJwks(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, 200, cfg.keys.JWKS())
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/sp3dr4/chirpy/internal/database"
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+func (cfg *apiConfig) handlerBlock(w http.ResponseWriter, req *http.Request) {
+	cfg.relate(w, req, cfg.db.Block)
+}
+
+func (cfg *apiConfig) handlerUnblock(w http.ResponseWriter, req *http.Request) {
+	cfg.relate(w, req, cfg.db.Unblock)
+}
+
+func (cfg *apiConfig) handlerMute(w http.ResponseWriter, req *http.Request) {
+	cfg.relate(w, req, cfg.db.Mute)
+}
+
+func (cfg *apiConfig) handlerUnmute(w http.ResponseWriter, req *http.Request) {
+	cfg.relate(w, req, cfg.db.Unmute)
+}
+
+// relate applies one of the idempotent block or mute operations
+// of the authenticated user on the user of the request path
+func (cfg *apiConfig) relate(w http.ResponseWriter, req *http.Request, op func(userId, targetId int) error) {
+	userId, err := cfg.isAuthenticated(req)
+	if err != nil {
+		respondWithError(w, 401, err.Error())
+		return
+	}
+	targetId, err := strconv.Atoi(req.PathValue("userId"))
+	if err != nil {
+		respondWithError(w, 400, "invalid integer for user id")
+		return
+	}
+	if err := op(userId, targetId); err != nil {
+		switch {
+		case errors.Is(err, database.ErrUserNotFound):
+			respondWithError(w, 404, "user not found")
+		case errors.Is(err, database.ErrSelfBlock):
+			respondWithError(w, 400, err.Error())
+		default:
+			respondWithError(w, 500, err.Error())
+		}
+		return
+	}
+	respondWithJSON(w, 204, struct{}{})
+}
+
+func (cfg *apiConfig) handlerListBlocked(w http.ResponseWriter, req *http.Request) {
+	cfg.listOwnUsers(w, req, cfg.db.GetBlocked)
+}
+
+func (cfg *apiConfig) handlerListMuted(w http.ResponseWriter, req *http.Request) {
+	cfg.listOwnUsers(w, req, cfg.db.GetMuted)
+}
+
+// listOwnUsers lists users related to the authenticated user,
+// such as the ones they block, which only they may see
+func (cfg *apiConfig) listOwnUsers(w http.ResponseWriter, req *http.Request, get func(userId int) ([]entities.User, error)) {
+	userId, err := cfg.isAuthenticated(req)
+	if err != nil {
+		respondWithError(w, 401, err.Error())
+		return
+	}
+	users, err := get(userId)
+	if err != nil {
+		respondWithError(w, 500, err.Error())
+		return
+	}
+	respondWithJSON(w, 200, usersResponse(users))
+}
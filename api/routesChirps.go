@@ -6,30 +6,24 @@ import (
 	"fmt"
 	"net/http"
 	"slices"
-	"sort"
 	"strconv"
 
+	"github.com/sp3dr4/chirpy/internal/database"
 	"github.com/sp3dr4/chirpy/internal/entities"
+	"github.com/sp3dr4/chirpy/internal/moderation"
 )
 
-var errNotFound = fmt.Errorf("chirp not found")
-
-func (cfg *apiConfig) findChirpById(id int) (*entities.Chirp, error) {
-	chirps, err := cfg.db.GetChirps(nil)
-	if err != nil {
-		return nil, err
-	}
-	i := slices.IndexFunc(chirps, func(c entities.Chirp) bool {
-		return c.Id == id
-	})
-	if i == -1 {
-		return nil, errNotFound
-	}
-	return &chirps[i], nil
+// findChirpById returns the chirp with the given id as seen by viewerId:
+// authors find all their chirps, other users only the ones they may read
+// in listings, according to moderation, blocks, mutes and visibility
+func (cfg *apiConfig) findChirpById(id int, viewerId *int) (*entities.Chirp, error) {
+	return cfg.db.GetVisibleChirp(id, viewerId)
 }
 
 func (cfg *apiConfig) handlerListChirps(w http.ResponseWriter, req *http.Request) {
-	userIdQuery := req.URL.Query().Get("author_id")
+	query := req.URL.Query()
+
+	userIdQuery := query.Get("author_id")
 	var byUserId *int
 	if userIdQuery != "" {
 		v, err := strconv.Atoi(userIdQuery)
@@ -40,43 +34,88 @@ func (cfg *apiConfig) handlerListChirps(w http.ResponseWriter, req *http.Request
 		byUserId = &v
 	}
 
-	chirps, err := cfg.db.GetChirps(byUserId)
+	desc, err := parseSort(query)
 	if err != nil {
-		respondWithError(w, 500, err.Error())
+		respondWithError(w, 400, err.Error())
 		return
 	}
 
-	sortFn := func(i, j int) bool { return chirps[i].Id < chirps[j].Id }
-	sortQuery := req.URL.Query().Get("sort")
-	if sortQuery != "" && sortQuery != "asc" {
-		if sortQuery == "desc" {
-			sortFn = func(i, j int) bool { return chirps[i].Id > chirps[j].Id }
-		} else {
-			respondWithError(w, 400, "invalid sort query parameter")
-			return
-		}
+	q := database.ChirpQuery{AuthorId: byUserId, Desc: desc}
+	// without pagination parameters the listing keeps the shape it had
+	// before pagination, a bare array of every chirp
+	if !query.Has("limit") && !query.Has("cursor") {
+		cfg.respondWithAllChirps(w, req, q)
+		return
 	}
-	sort.Slice(chirps, sortFn)
+	cfg.respondWithChirpPage(w, req, q)
+}
 
-	respondWithJSON(w, 200, chirps)
+// handlerInbox lists the direct chirps addressed to the authenticated user
+func (cfg *apiConfig) handlerInbox(w http.ResponseWriter, req *http.Request) {
+	if _, err := cfg.isAuthenticated(req); err != nil {
+		respondWithError(w, 401, err.Error())
+		return
+	}
+	desc, err := parseSort(req.URL.Query())
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	cfg.respondWithChirpPage(w, req, database.ChirpQuery{Inbox: true, Desc: desc})
 }
 
 func (cfg *apiConfig) handlerGetChirp(w http.ResponseWriter, req *http.Request) {
+	viewerId, err := cfg.viewer(req)
+	if err != nil {
+		respondWithError(w, 401, err.Error())
+		return
+	}
 	chirpId, err := strconv.Atoi(req.PathValue("chirpId"))
 	if err != nil {
 		respondWithError(w, 400, "invalid integer for chirp id")
 		return
 	}
-	chirp, err := cfg.findChirpById(chirpId)
+	chirp, err := cfg.findChirpById(chirpId, viewerId)
 	if err != nil {
-		if errors.Is(err, errNotFound) {
+		if errors.Is(err, database.ErrChirpNotFound) {
 			respondWithError(w, 404, "chirp not found")
 		} else {
 			respondWithError(w, 500, err.Error())
 		}
 		return
 	}
-	respondWithJSON(w, 200, chirp)
+	resp, err := cfg.chirpResponse(*chirp, viewerId)
+	if err != nil {
+		respondWithError(w, 500, err.Error())
+		return
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+// respondWithValidationError reports an invalid chirp body
+// along with the rule it breaks
+func respondWithValidationError(w http.ResponseWriter, err error) {
+	type respErr struct {
+		Error string `json:"error"`
+		Rule  string `json:"rule"`
+	}
+	var validationErr *entities.ValidationError
+	if !errors.As(err, &validationErr) {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	respondWithJSON(w, 400, respErr{Error: validationErr.Message, Rule: validationErr.Rule})
+}
+
+// moderate runs a chirp body through the moderation rules,
+// responding with an error when one of them rejects it
+func (cfg *apiConfig) moderate(w http.ResponseWriter, body string) (moderation.Result, bool) {
+	res := cfg.moderator.Moderate(body)
+	if res.Rejected != "" {
+		respondWithError(w, 400, fmt.Sprintf("chirp rejected by moderation rule %q", res.Rejected))
+		return res, false
+	}
+	return res, true
 }
 
 func (cfg *apiConfig) handlerCreateChirp(w http.ResponseWriter, req *http.Request) {
@@ -87,24 +126,53 @@ func (cfg *apiConfig) handlerCreateChirp(w http.ResponseWriter, req *http.Reques
 	}
 
 	type request struct {
-		Body string `json:"body"`
+		Body       string `json:"body"`
+		InReplyTo  *int   `json:"in_reply_to"`
+		Visibility string `json:"visibility"`
 	}
 	chirpReq := request{}
 	if err := json.NewDecoder(req.Body).Decode(&chirpReq); err != nil {
 		respondWithError(w, 400, "error decoding request body")
 		return
 	}
-	cleaned, err := entities.ValidateChirp(chirpReq.Body)
+	visibility, err := entities.ParseVisibility(chirpReq.Visibility)
 	if err != nil {
 		respondWithError(w, 400, err.Error())
 		return
 	}
-	chirp, err := cfg.db.CreateChirp(userId, cleaned)
+	cleaned, err := entities.ValidateChirp(chirpReq.Body)
+	if err != nil {
+		respondWithValidationError(w, err)
+		return
+	}
+	moderated, ok := cfg.moderate(w, cleaned)
+	if !ok {
+		return
+	}
+	chirp, err := cfg.db.CreateChirp(entities.Chirp{
+		UserId:     userId,
+		Body:       moderated.Text,
+		InReplyTo:  chirpReq.InReplyTo,
+		Flags:      moderated.Flags,
+		Visibility: visibility,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrParentNotFound), errors.Is(err, database.ErrNoRecipients):
+			respondWithError(w, 400, err.Error())
+		case errors.Is(err, database.ErrBlocked):
+			respondWithError(w, 403, err.Error())
+		default:
+			respondWithError(w, 500, err.Error())
+		}
+		return
+	}
+	resp, err := cfg.chirpResponse(*chirp, &userId)
 	if err != nil {
 		respondWithError(w, 500, err.Error())
 		return
 	}
-	respondWithJSON(w, 201, chirp)
+	respondWithJSON(w, 201, resp)
 }
 
 func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, req *http.Request) {
@@ -118,9 +186,9 @@ func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, req *http.Reques
 		respondWithError(w, 400, "invalid integer for chirp id")
 		return
 	}
-	chirp, err := cfg.findChirpById(chirpId)
+	chirp, err := cfg.findChirpById(chirpId, &userId)
 	if err != nil {
-		if errors.Is(err, errNotFound) {
+		if errors.Is(err, database.ErrChirpNotFound) {
 			respondWithError(w, 404, "chirp not found")
 		} else {
 			respondWithError(w, 500, err.Error())
@@ -137,3 +205,94 @@ func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, req *http.Reques
 	}
 	respondWithJSON(w, 204, struct{}{})
 }
+
+func (cfg *apiConfig) handlerUpdateChirp(w http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.isAuthenticated(req)
+	if err != nil {
+		respondWithError(w, 401, err.Error())
+		return
+	}
+	chirpId, err := strconv.Atoi(req.PathValue("chirpId"))
+	if err != nil {
+		respondWithError(w, 400, "invalid integer for chirp id")
+		return
+	}
+
+	type request struct {
+		Body string `json:"body"`
+	}
+	chirpReq := request{}
+	if err := json.NewDecoder(req.Body).Decode(&chirpReq); err != nil {
+		respondWithError(w, 400, "error decoding request body")
+		return
+	}
+
+	chirp, err := cfg.findChirpById(chirpId, &userId)
+	if err != nil {
+		if errors.Is(err, database.ErrChirpNotFound) {
+			respondWithError(w, 404, "chirp not found")
+		} else {
+			respondWithError(w, 500, err.Error())
+		}
+		return
+	}
+	if chirp.UserId != userId {
+		respondWithError(w, 403, "forbidden")
+		return
+	}
+	cleaned, err := entities.ValidateChirp(chirpReq.Body)
+	if err != nil {
+		respondWithValidationError(w, err)
+		return
+	}
+	moderated, ok := cfg.moderate(w, cleaned)
+	if !ok {
+		return
+	}
+	// an unchanged body still takes the flags of the rules reloaded since
+	if moderated.Text != chirp.Body || !slices.Equal(moderated.Flags, chirp.Flags) {
+		chirp, err = cfg.db.UpdateChirp(chirp.Id, moderated.Text, moderated.Flags)
+		if err != nil {
+			if errors.Is(err, database.ErrNoRecipients) {
+				respondWithError(w, 400, err.Error())
+			} else {
+				respondWithError(w, 500, err.Error())
+			}
+			return
+		}
+	}
+	resp, err := cfg.chirpResponse(*chirp, &userId)
+	if err != nil {
+		respondWithError(w, 500, err.Error())
+		return
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+func (cfg *apiConfig) handlerChirpHistory(w http.ResponseWriter, req *http.Request) {
+	viewerId, err := cfg.viewer(req)
+	if err != nil {
+		respondWithError(w, 401, err.Error())
+		return
+	}
+	chirpId, err := strconv.Atoi(req.PathValue("chirpId"))
+	if err != nil {
+		respondWithError(w, 400, "invalid integer for chirp id")
+		return
+	}
+	chirp, err := cfg.findChirpById(chirpId, viewerId)
+	if err != nil {
+		if errors.Is(err, database.ErrChirpNotFound) {
+			respondWithError(w, 404, "chirp not found")
+		} else {
+			respondWithError(w, 500, err.Error())
+		}
+		return
+	}
+	revisions, err := cfg.db.GetChirpHistory(chirp.Id)
+	if err != nil {
+		respondWithError(w, 500, err.Error())
+		return
+	}
+	respondWithJSON(w, 200, revisions)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/sp3dr4/chirpy/internal/database"
+	"github.com/sp3dr4/chirpy/internal/entities"
+	"github.com/sp3dr4/chirpy/internal/moderation"
+)
+
+func TestUpdateChirpTakesReloadedFlags(t *testing.T) {
+	rules := filepath.Join(t.TempDir(), "moderation.yaml")
+	if err := os.WriteFile(rules, []byte("rules: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	moderator, err := moderation.NewModerator(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := database.NewMemoryDB()
+	cfg := &apiConfig{db: db, moderator: moderator, keys: newTestKeyring(t)}
+	user, err := db.CreateUser("a@example.com", "hash", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chirp, err := db.CreateChirp(entities.Chirp{UserId: user.Id, Body: "free bitcoin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessToken, err := newAccessToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := createJwt(*user, accessToken, cfg.keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flagRule := "rules:\n  - name: scams\n    action: flag\n    pattern: '(?i)free\\s+bitcoin'\n"
+	if err := os.WriteFile(rules, []byte(flagRule), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := moderator.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("PUT", fmt.Sprintf("/api/chirps/%d", chirp.Id), strings.NewReader(`{"body":"free bitcoin"}`))
+	req.SetPathValue("chirpId", fmt.Sprint(chirp.Id))
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	cfg.handlerUpdateChirp(w, req)
+	if w.Code != 200 {
+		t.Fatalf("got status %d, body %s", w.Code, w.Body)
+	}
+	updated, err := db.GetChirpByID(chirp.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(updated.Flags, []string{"scams"}) {
+		t.Fatalf("got flags %v, want the reloaded rule", updated.Flags)
+	}
+}
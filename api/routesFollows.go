@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sp3dr4/chirpy/internal/database"
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+func usersResponse(users []entities.User) []publicUserResponse {
+	resp := make([]publicUserResponse, 0, len(users))
+	for _, u := range users {
+		resp = append(resp, newPublicUserResponse(u))
+	}
+	return resp
+}
+
+func (cfg *apiConfig) handlerFollow(w http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.isAuthenticated(req)
+	if err != nil {
+		respondWithError(w, 401, err.Error())
+		return
+	}
+	followeeId, err := strconv.Atoi(req.PathValue("userId"))
+	if err != nil {
+		respondWithError(w, 400, "invalid integer for user id")
+		return
+	}
+	if err := cfg.db.Follow(userId, followeeId); err != nil {
+		switch {
+		case errors.Is(err, database.ErrUserNotFound):
+			respondWithError(w, 404, "user not found")
+		case errors.Is(err, database.ErrSelfFollow):
+			respondWithError(w, 400, err.Error())
+		case errors.Is(err, database.ErrBlocked):
+			respondWithError(w, 403, err.Error())
+		default:
+			respondWithError(w, 500, err.Error())
+		}
+		return
+	}
+	respondWithJSON(w, 204, struct{}{})
+}
+
+func (cfg *apiConfig) handlerUnfollow(w http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.isAuthenticated(req)
+	if err != nil {
+		respondWithError(w, 401, err.Error())
+		return
+	}
+	followeeId, err := strconv.Atoi(req.PathValue("userId"))
+	if err != nil {
+		respondWithError(w, 400, "invalid integer for user id")
+		return
+	}
+	if err := cfg.db.Unfollow(userId, followeeId); err != nil {
+		respondWithError(w, 500, err.Error())
+		return
+	}
+	respondWithJSON(w, 204, struct{}{})
+}
+
+func (cfg *apiConfig) handlerListFollowers(w http.ResponseWriter, req *http.Request) {
+	cfg.listUsers(w, req, cfg.db.GetFollowers)
+}
+
+func (cfg *apiConfig) handlerListFollowing(w http.ResponseWriter, req *http.Request) {
+	cfg.listUsers(w, req, cfg.db.GetFollowing)
+}
+
+func (cfg *apiConfig) listUsers(w http.ResponseWriter, req *http.Request, get func(userId int) ([]entities.User, error)) {
+	userId, err := strconv.Atoi(req.PathValue("userId"))
+	if err != nil {
+		respondWithError(w, 400, "invalid integer for user id")
+		return
+	}
+	users, err := get(userId)
+	if err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			respondWithError(w, 404, "user not found")
+		} else {
+			respondWithError(w, 500, err.Error())
+		}
+		return
+	}
+	respondWithJSON(w, 200, usersResponse(users))
+}
+
+// timelineItemResponse is a chirp of the home timeline,
+// with who rechirped it and when if it got there through a rechirp
+type timelineItemResponse struct {
+	chirpResponse
+	RechirpedBy *int       `json:"rechirped_by,omitempty"`
+	RechirpedAt *time.Time `json:"rechirped_at,omitempty"`
+}
+
+func (cfg *apiConfig) handlerTimeline(w http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.isAuthenticated(req)
+	if err != nil {
+		respondWithError(w, 401, err.Error())
+		return
+	}
+	items, err := cfg.db.GetTimeline(userId)
+	if err != nil {
+		respondWithError(w, 500, err.Error())
+		return
+	}
+	chirps := make([]entities.Chirp, 0, len(items))
+	for _, item := range items {
+		chirps = append(chirps, item.Chirp)
+	}
+	withStats, err := cfg.chirpResponses(chirps, &userId)
+	if err != nil {
+		respondWithError(w, 500, err.Error())
+		return
+	}
+	resp := make([]timelineItemResponse, 0, len(items))
+	for i, item := range items {
+		r := timelineItemResponse{chirpResponse: withStats[i]}
+		if item.Rechirp != nil {
+			r.RechirpedBy = &item.Rechirp.UserId
+			r.RechirpedAt = &item.Rechirp.CreatedAt
+		}
+		resp = append(resp, r)
+	}
+	respondWithJSON(w, 200, resp)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sp3dr4/chirpy/internal/database"
+)
+
+func TestFollowListsHideEmails(t *testing.T) {
+	db := database.NewMemoryDB()
+	a, err := db.CreateUser("a@example.com", "hash", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := db.CreateUser("b@example.com", "hash", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Follow(a.Id, b.Id); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{db: db}
+
+	lists := map[string]struct {
+		handler http.HandlerFunc
+		userId  int
+		want    int
+	}{
+		"followers": {cfg.handlerListFollowers, b.Id, a.Id},
+		"following": {cfg.handlerListFollowing, a.Id, b.Id},
+	}
+	for name, list := range lists {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", fmt.Sprintf("/api/users/%d/%s", list.userId, name), nil)
+			req.SetPathValue("userId", fmt.Sprint(list.userId))
+			w := httptest.NewRecorder()
+			list.handler(w, req)
+			if w.Code != 200 {
+				t.Fatalf("got status %d, body %s", w.Code, w.Body)
+			}
+			var users []map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil {
+				t.Fatal(err)
+			}
+			if len(users) != 1 || users[0]["id"] != float64(list.want) {
+				t.Fatalf("got %v, want user %d", users, list.want)
+			}
+			if _, found := users[0]["email"]; found {
+				t.Errorf("the list shows the email: %s", w.Body)
+			}
+			if _, found := users[0]["handle"]; !found {
+				t.Errorf("the list misses the handle: %s", w.Body)
+			}
+		})
+	}
+}
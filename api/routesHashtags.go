@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sp3dr4/chirpy/internal/database"
+)
+
+const defaultTrendingWindow = 24 * time.Hour
+const maxTrendingWindow = 7 * 24 * time.Hour
+
+func (cfg *apiConfig) handlerHashtagChirps(w http.ResponseWriter, req *http.Request) {
+	tag := strings.ToLower(strings.TrimPrefix(req.PathValue("tag"), "#"))
+	if tag == "" {
+		respondWithError(w, 400, "invalid hashtag")
+		return
+	}
+	desc, err := parseSort(req.URL.Query())
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	cfg.respondWithChirpPage(w, req, database.ChirpQuery{Hashtag: tag, Desc: desc})
+}
+
+func (cfg *apiConfig) handlerTrendingHashtags(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+
+	window := defaultTrendingWindow
+	if windowQuery := query.Get("window"); windowQuery != "" {
+		var err error
+		window, err = time.ParseDuration(windowQuery)
+		if err != nil || window <= 0 || window > maxTrendingWindow {
+			respondWithError(w, 400, fmt.Sprintf("window must be a duration such as 1h30m, up to %s", maxTrendingWindow))
+			return
+		}
+	}
+
+	limit, err := parseLimit(query)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	trending, err := cfg.db.TrendingHashtags(time.Now().Add(-window), limit)
+	if err != nil {
+		respondWithError(w, 500, err.Error())
+		return
+	}
+	respondWithJSON(w, 200, trending)
+}
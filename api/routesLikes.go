@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/sp3dr4/chirpy/internal/database"
+)
+
+func (cfg *apiConfig) handlerLike(w http.ResponseWriter, req *http.Request) {
+	cfg.engage(w, req, cfg.db.Like)
+}
+
+func (cfg *apiConfig) handlerUnlike(w http.ResponseWriter, req *http.Request) {
+	cfg.engage(w, req, cfg.db.Unlike)
+}
+
+func (cfg *apiConfig) handlerRechirp(w http.ResponseWriter, req *http.Request) {
+	cfg.engage(w, req, cfg.db.Rechirp)
+}
+
+func (cfg *apiConfig) handlerUnrechirp(w http.ResponseWriter, req *http.Request) {
+	cfg.engage(w, req, cfg.db.Unrechirp)
+}
+
+// engage applies one of the idempotent like or rechirp operations
+// of the authenticated user on the chirp of the request path
+func (cfg *apiConfig) engage(w http.ResponseWriter, req *http.Request, op func(userId, chirpId int) error) {
+	userId, err := cfg.isAuthenticated(req)
+	if err != nil {
+		respondWithError(w, 401, err.Error())
+		return
+	}
+	chirpId, err := strconv.Atoi(req.PathValue("chirpId"))
+	if err != nil {
+		respondWithError(w, 400, "invalid integer for chirp id")
+		return
+	}
+	if err := op(userId, chirpId); err != nil {
+		switch {
+		case errors.Is(err, database.ErrChirpNotFound):
+			respondWithError(w, 404, "chirp not found")
+		case errors.Is(err, database.ErrBlocked), errors.Is(err, database.ErrPrivateChirp):
+			respondWithError(w, 403, err.Error())
+		default:
+			respondWithError(w, 500, err.Error())
+		}
+		return
+	}
+	respondWithJSON(w, 204, struct{}{})
+}
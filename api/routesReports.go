@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/sp3dr4/chirpy/internal/database"
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+// reportActions maps the admin actions on a report to the status they resolve it with
+var reportActions = map[string]entities.ReportStatus{
+	"dismiss": entities.ReportDismissed,
+	"hide":    entities.ReportChirpHidden,
+	"suspend": entities.ReportUserSuspended,
+}
+
+// reportResponse shows admins the reported chirp, flags included,
+// as long as it was not deleted
+type reportResponse struct {
+	entities.Report
+	Chirp *entities.Chirp `json:"chirp,omitempty"`
+}
+
+func (cfg *apiConfig) handlerCreateReport(w http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.isAuthenticated(req)
+	if err != nil {
+		respondWithError(w, 401, err.Error())
+		return
+	}
+
+	type request struct {
+		ChirpId *int   `json:"chirp_id"`
+		UserId  *int   `json:"user_id"`
+		Reason  string `json:"reason"`
+	}
+	reportReq := request{}
+	if err := json.NewDecoder(req.Body).Decode(&reportReq); err != nil {
+		respondWithError(w, 400, "error decoding request body")
+		return
+	}
+	if (reportReq.ChirpId == nil) == (reportReq.UserId == nil) {
+		respondWithError(w, 400, "report either a chirp_id or a user_id")
+		return
+	}
+	reason, err := entities.ValidateReportReason(reportReq.Reason)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	report := entities.Report{ReporterId: &userId, ChirpId: reportReq.ChirpId, Reason: reason}
+	if reportReq.UserId != nil {
+		report.UserId = *reportReq.UserId
+	}
+	created, err := cfg.db.CreateReport(report)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrChirpNotFound):
+			respondWithError(w, 404, "chirp not found")
+		case errors.Is(err, database.ErrUserNotFound):
+			respondWithError(w, 404, "user not found")
+		case errors.Is(err, database.ErrDuplicateReport):
+			respondWithError(w, 409, err.Error())
+		default:
+			respondWithError(w, 500, err.Error())
+		}
+		return
+	}
+	respondWithJSON(w, 201, created)
+}
+
+func (cfg *apiConfig) handlerListReports(w http.ResponseWriter, req *http.Request) {
+	if !cfg.isAdmin(req) {
+		respondWithError(w, 401, "unauthorized")
+		return
+	}
+	status := entities.ReportOpen
+	if v, ok := req.URL.Query()["status"]; ok {
+		status = entities.ReportStatus(v[0])
+	}
+	switch status {
+	case "", entities.ReportOpen, entities.ReportDismissed, entities.ReportChirpHidden, entities.ReportUserSuspended:
+	default:
+		respondWithError(w, 400, "invalid report status")
+		return
+	}
+	reports, err := cfg.db.GetReports(status)
+	if err != nil {
+		respondWithError(w, 500, err.Error())
+		return
+	}
+	resp := make([]reportResponse, 0, len(reports))
+	for _, r := range reports {
+		item := reportResponse{Report: r}
+		if r.ChirpId != nil {
+			chirp, err := cfg.db.GetChirpByID(*r.ChirpId)
+			if err != nil && !errors.Is(err, database.ErrChirpNotFound) {
+				respondWithError(w, 500, err.Error())
+				return
+			}
+			item.Chirp = chirp
+		}
+		resp = append(resp, item)
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+func (cfg *apiConfig) handlerResolveReport(w http.ResponseWriter, req *http.Request) {
+	if !cfg.isAdmin(req) {
+		respondWithError(w, 401, "unauthorized")
+		return
+	}
+	reportId, err := strconv.Atoi(req.PathValue("reportId"))
+	if err != nil {
+		respondWithError(w, 400, "invalid integer for report id")
+		return
+	}
+
+	type request struct {
+		Action string `json:"action"`
+	}
+	resolveReq := request{}
+	if err := json.NewDecoder(req.Body).Decode(&resolveReq); err != nil {
+		respondWithError(w, 400, "error decoding request body")
+		return
+	}
+	status, ok := reportActions[resolveReq.Action]
+	if !ok {
+		respondWithError(w, 400, "action must be dismiss, hide or suspend")
+		return
+	}
+	report, err := cfg.db.ResolveReport(reportId, status)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrReportNotFound):
+			respondWithError(w, 404, "report not found")
+		case errors.Is(err, database.ErrReportResolved):
+			respondWithError(w, 409, err.Error())
+		case errors.Is(err, database.ErrNoReportedChirp), errors.Is(err, database.ErrChirpNotFound):
+			respondWithError(w, 400, err.Error())
+		default:
+			respondWithError(w, 500, err.Error())
+		}
+		return
+	}
+	respondWithJSON(w, 200, report)
+}
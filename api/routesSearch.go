@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/sp3dr4/chirpy/internal/database"
+)
+
+func (cfg *apiConfig) handlerSearchChirps(w http.ResponseWriter, req *http.Request) {
+	type response struct {
+		Chirps []chirpResponse `json:"chirps"`
+	}
+	query := req.URL.Query()
+
+	viewerId, err := cfg.viewer(req)
+	if err != nil {
+		respondWithError(w, 401, err.Error())
+		return
+	}
+
+	var byUserId *int
+	if userIdQuery := query.Get("author_id"); userIdQuery != "" {
+		v, err := strconv.Atoi(userIdQuery)
+		if err != nil {
+			respondWithError(w, 400, err.Error())
+			return
+		}
+		byUserId = &v
+	}
+
+	limit, err := parseLimit(query)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	chirps, err := cfg.db.SearchChirps(database.SearchQuery{
+		Text:     query.Get("q"),
+		AuthorId: byUserId,
+		Limit:    limit,
+		ViewerId: viewerId,
+	})
+	if errors.Is(err, database.ErrEmptySearch) {
+		respondWithError(w, 400, "q must contain at least one word")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 500, err.Error())
+		return
+	}
+	resp, err := cfg.chirpResponses(chirps, viewerId)
+	if err != nil {
+		respondWithError(w, 500, err.Error())
+		return
+	}
+	respondWithJSON(w, 200, response{Chirps: resp})
+}
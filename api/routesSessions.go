@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sp3dr4/chirpy/internal/database"
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+// sessionResponse is a session without the hash of its refresh token
+type sessionResponse struct {
+	Id         int       `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+func newSessionResponse(s entities.Session) sessionResponse {
+	return sessionResponse{
+		Id:         s.Id,
+		UserAgent:  s.UserAgent,
+		IP:         s.IP,
+		CreatedAt:  s.CreatedAt,
+		LastUsedAt: s.LastUsedAt,
+		ExpiresAt:  s.ExpiresAt,
+	}
+}
+
+// clientIP returns the address the request comes from, without its port
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+func (cfg *apiConfig) handlerListSessions(w http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.isAuthenticated(req)
+	if err != nil {
+		respondWithError(w, 401, err.Error())
+		return
+	}
+	sessions, err := cfg.db.GetSessions(userId)
+	if err != nil {
+		respondWithError(w, 500, err.Error())
+		return
+	}
+	resp := make([]sessionResponse, 0, len(sessions))
+	for _, s := range sessions {
+		resp = append(resp, newSessionResponse(s))
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+// handlerDeleteSession logs the authenticated user out of one device
+func (cfg *apiConfig) handlerDeleteSession(w http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.isAuthenticated(req)
+	if err != nil {
+		respondWithError(w, 401, err.Error())
+		return
+	}
+	sessionId, err := strconv.Atoi(req.PathValue("sessionId"))
+	if err != nil {
+		respondWithError(w, 400, "invalid integer for session id")
+		return
+	}
+	if err := cfg.db.DeleteSession(userId, sessionId); err != nil {
+		if errors.Is(err, database.ErrSessionNotFound) {
+			respondWithError(w, 404, err.Error())
+		} else {
+			respondWithError(w, 500, err.Error())
+		}
+		return
+	}
+	respondWithJSON(w, 204, struct{}{})
+}
+
+// handlerDeleteSessions logs the authenticated user out everywhere
+func (cfg *apiConfig) handlerDeleteSessions(w http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.isAuthenticated(req)
+	if err != nil {
+		respondWithError(w, 401, err.Error())
+		return
+	}
+	if err := cfg.db.DeleteUserSessions(userId); err != nil {
+		respondWithError(w, 500, err.Error())
+		return
+	}
+	respondWithJSON(w, 204, struct{}{})
+}
+
+// handlerListSecurityEvents lists the suspicious uses of the account of
+// the authenticated user, such as a stolen refresh token
+func (cfg *apiConfig) handlerListSecurityEvents(w http.ResponseWriter, req *http.Request) {
+	userId, err := cfg.isAuthenticated(req)
+	if err != nil {
+		respondWithError(w, 401, err.Error())
+		return
+	}
+	events, err := cfg.db.GetSecurityEvents(userId)
+	if err != nil {
+		respondWithError(w, 500, err.Error())
+		return
+	}
+	respondWithJSON(w, 200, events)
+}
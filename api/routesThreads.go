@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/sp3dr4/chirpy/internal/database"
+)
+
+// threadNode is a chirp of a conversation along with its replies.
+// Chirps the viewer cannot see, because they were deleted or hidden, are
+// private or by a blocked author, are kept as placeholders when they still
+// have replies. The placeholders do not tell which case it is.
+type threadNode struct {
+	Id          int  `json:"id"`
+	Unavailable bool `json:"unavailable,omitempty"`
+	*chirpResponse
+	Replies []*threadNode `json:"replies"`
+}
+
+// buildThread arranges the chirps of a conversation into a tree, replies
+// sorted oldest first. A missing chirp only lives on through the replies
+// pointing to it: its own parent is unknown, so its placeholder hangs
+// from the root.
+func buildThread(rootId int, chirps []chirpResponse) *threadNode {
+	nodes := map[int]*threadNode{}
+	nodeFor := func(id int) *threadNode {
+		n, ok := nodes[id]
+		if !ok {
+			n = &threadNode{Id: id, Unavailable: true, Replies: []*threadNode{}}
+			nodes[id] = n
+		}
+		return n
+	}
+	for i := range chirps {
+		n := nodeFor(chirps[i].Id)
+		n.chirpResponse = &chirps[i]
+		n.Unavailable = false
+	}
+
+	root := nodeFor(rootId)
+	for _, c := range chirps {
+		if c.InReplyTo == nil {
+			continue
+		}
+		parent := nodeFor(*c.InReplyTo)
+		parent.Replies = append(parent.Replies, nodes[c.Id])
+	}
+	for id, n := range nodes {
+		if n.Unavailable && id != rootId {
+			root.Replies = append(root.Replies, n)
+		}
+	}
+
+	var sortReplies func(n *threadNode)
+	sortReplies = func(n *threadNode) {
+		sort.Slice(n.Replies, func(i, j int) bool {
+			a, b := n.Replies[i], n.Replies[j]
+			// placeholders have no date, keep them in id order
+			if a.chirpResponse == nil || b.chirpResponse == nil || a.CreatedAt.Equal(b.CreatedAt) {
+				return a.Id < b.Id
+			}
+			return a.CreatedAt.Before(b.CreatedAt)
+		})
+		for _, r := range n.Replies {
+			sortReplies(r)
+		}
+	}
+	sortReplies(root)
+	return root
+}
+
+func (cfg *apiConfig) handlerChirpThread(w http.ResponseWriter, req *http.Request) {
+	viewerId, err := cfg.viewer(req)
+	if err != nil {
+		respondWithError(w, 401, err.Error())
+		return
+	}
+	chirpId, err := strconv.Atoi(req.PathValue("chirpId"))
+	if err != nil {
+		respondWithError(w, 400, "invalid integer for chirp id")
+		return
+	}
+	chirp, err := cfg.findChirpById(chirpId, viewerId)
+	if err != nil {
+		if errors.Is(err, database.ErrChirpNotFound) {
+			respondWithError(w, 404, "chirp not found")
+		} else {
+			respondWithError(w, 500, err.Error())
+		}
+		return
+	}
+	rootId := chirp.ThreadRootId()
+	// chirps the viewer may not read show as placeholders, the same as deleted ones
+	chirps, err := cfg.db.GetThread(rootId, viewerId)
+	if err != nil {
+		respondWithError(w, 500, err.Error())
+		return
+	}
+	resp, err := cfg.chirpResponses(chirps, viewerId)
+	if err != nil {
+		respondWithError(w, 500, err.Error())
+		return
+	}
+	respondWithJSON(w, 200, buildThread(rootId, resp))
+}
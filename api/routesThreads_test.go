@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+func TestBuildThreadPlaceholders(t *testing.T) {
+	parent := 2
+	// chirp 2 is missing: deleted, hidden, private or by a blocked author
+	chirps := []chirpResponse{
+		{Chirp: entities.Chirp{Id: 1, Body: "root"}},
+		{Chirp: entities.Chirp{Id: 3, Body: "reply", InReplyTo: &parent}},
+	}
+	root := buildThread(1, chirps)
+	if len(root.Replies) != 1 {
+		t.Fatalf("got %d replies to the root, want the placeholder", len(root.Replies))
+	}
+	placeholder := root.Replies[0]
+	if placeholder.Id != 2 || !placeholder.Unavailable || placeholder.chirpResponse != nil {
+		t.Fatalf("got %+v, want an unavailable placeholder for chirp 2", placeholder)
+	}
+	if len(placeholder.Replies) != 1 || placeholder.Replies[0].Id != 3 {
+		t.Fatal("the reply to the missing chirp is not under its placeholder")
+	}
+
+	dat, err := json.Marshal(placeholder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(dat), `"unavailable":true`) || strings.Contains(string(dat), "deleted") {
+		t.Fatalf("placeholder renders as %s", dat)
+	}
+}
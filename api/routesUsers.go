@@ -5,7 +5,6 @@ import (
 	"errors"
 	"log"
 	"net/http"
-	"slices"
 	"strings"
 
 	"github.com/sp3dr4/chirpy/internal/database"
@@ -16,12 +15,29 @@ import (
 type userRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	// Handle is optional, users keep their current one when empty
+	Handle string `json:"handle"`
 }
 
 type userResponse struct {
 	Id          int    `json:"id"`
 	Email       string `json:"email"`
 	IsChirpyRed bool   `json:"is_chirpy_red"`
+	Handle      string `json:"handle"`
+}
+
+func newUserResponse(u entities.User) userResponse {
+	return userResponse{Id: u.Id, Email: u.Email, IsChirpyRed: u.IsChirpyRed, Handle: u.Handle}
+}
+
+// publicUserResponse is what anyone may see of a user, their email stays private
+type publicUserResponse struct {
+	Id     int    `json:"id"`
+	Handle string `json:"handle"`
+}
+
+func newPublicUserResponse(u entities.User) publicUserResponse {
+	return publicUserResponse{Id: u.Id, Handle: u.Handle}
 }
 
 func (cfg *apiConfig) handlerCreateUser(w http.ResponseWriter, req *http.Request) {
@@ -44,7 +60,7 @@ func (cfg *apiConfig) handlerCreateUser(w http.ResponseWriter, req *http.Request
 		respondWithError(w, code, err.Error())
 		return
 	}
-	respondWithJSON(w, 201, userResponse{Id: user.Id, Email: user.Email, IsChirpyRed: user.IsChirpyRed})
+	respondWithJSON(w, 201, newUserResponse(*user))
 }
 
 func (cfg *apiConfig) handlerUpdateUser(w http.ResponseWriter, req *http.Request) {
@@ -61,34 +77,54 @@ func (cfg *apiConfig) handlerUpdateUser(w http.ResponseWriter, req *http.Request
 		return
 	}
 
-	users, err := cfg.db.GetUsers()
-	if err != nil {
-		respondWithError(w, 500, err.Error())
-		return
+	handle := strings.ToLower(userReq.Handle)
+	if handle != "" {
+		if err := entities.ValidateHandle(handle); err != nil {
+			respondWithError(w, 400, err.Error())
+			return
+		}
 	}
-	i := slices.IndexFunc(users, func(c entities.User) bool {
-		return c.Id == userId
-	})
-	if i == -1 {
-		respondWithError(w, 404, "user not found")
+
+	user, err := cfg.db.GetUserByID(userId)
+	if err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			respondWithError(w, 404, "user not found")
+		} else {
+			respondWithError(w, 500, err.Error())
+		}
 		return
 	}
-	user := users[i]
 	paswHash, err := bcrypt.GenerateFromPassword([]byte(userReq.Password), bcrypt.DefaultCost)
 	if err != nil {
 		respondWithError(w, 500, "something went wrong")
 		return
 	}
-	if user.Email != strings.ToLower(userReq.Email) || user.Password != string(paswHash) {
+	if handle == "" {
+		handle = user.Handle
+	}
+	passwordChanged := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(userReq.Password)) != nil
+	if user.Email != strings.ToLower(userReq.Email) || user.Password != string(paswHash) || user.Handle != handle {
 		user.Email = strings.ToLower(userReq.Email)
 		user.Password = string(paswHash)
-		updated, err := cfg.db.UpdateUser(&user)
+		user.Handle = handle
+		user, err = cfg.db.UpdateUser(user)
 		if err != nil {
+			if errors.Is(err, database.ErrDuplicateUser) || errors.Is(err, database.ErrDuplicateHandle) {
+				respondWithError(w, 400, err.Error())
+			} else {
+				respondWithError(w, 500, "something went wrong")
+			}
+			return
+		}
+	}
+	// a new password logs the user out everywhere, revoking the access
+	// tokens of every session along with it
+	if passwordChanged {
+		if err := cfg.db.DeleteUserSessions(userId); err != nil {
 			respondWithError(w, 500, "something went wrong")
 			return
 		}
-		user = *updated
 	}
 
-	respondWithJSON(w, 200, userResponse{Id: user.Id, Email: user.Email, IsChirpyRed: user.IsChirpyRed})
+	respondWithJSON(w, 200, newUserResponse(*user))
 }
@@ -2,11 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
-	"slices"
 	"strings"
 
-	"github.com/sp3dr4/chirpy/internal/entities"
+	"github.com/sp3dr4/chirpy/internal/database"
 )
 
 type Payload struct {
@@ -24,21 +24,17 @@ func (cfg *apiConfig) userUpgradedCallback(w http.ResponseWriter, payload Payloa
 		return
 	}
 
-	users, err := cfg.db.GetUsers()
+	user, err := cfg.db.GetUserByID(data.UserID)
 	if err != nil {
-		respondWithError(w, 500, err.Error())
-		return
-	}
-	i := slices.IndexFunc(users, func(c entities.User) bool {
-		return c.Id == data.UserID
-	})
-	if i == -1 {
-		respondWithError(w, 404, "user not found")
+		if errors.Is(err, database.ErrUserNotFound) {
+			respondWithError(w, 404, "user not found")
+		} else {
+			respondWithError(w, 500, err.Error())
+		}
 		return
 	}
-	user := users[i]
 	user.IsChirpyRed = true
-	_, err = cfg.db.UpdateUser(&user)
+	_, err = cfg.db.UpdateUser(user)
 	if err != nil {
 		respondWithError(w, 500, err.Error())
 		return
@@ -0,0 +1,88 @@
+package database
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+var ErrSelfBlock = errors.New("users cannot block or mute themselves")
+var ErrBlocked = errors.New("blocked by the user")
+
+// Block is an idempotent operation that makes blockerId block userId.
+// Any follow between the two users is dropped.
+func (db *DB) Block(blockerId, userId int) error {
+	if blockerId == userId {
+		return ErrSelfBlock
+	}
+	return db.Update(func(tx *Tx) error {
+		if _, found := tx.User(userId); !found {
+			return ErrUserNotFound
+		}
+		for _, pair := range [][2]int{{blockerId, userId}, {userId, blockerId}} {
+			following := tx.Following(pair[0])
+			if i := slices.Index(following, pair[1]); i != -1 {
+				if err := tx.PutFollowing(pair[0], slices.Delete(following, i, i+1)); err != nil {
+					return err
+				}
+			}
+		}
+		blocked := tx.Blocked(blockerId)
+		if slices.Contains(blocked, userId) {
+			return nil
+		}
+		return tx.PutBlocked(blockerId, append(blocked, userId))
+	})
+}
+
+// Unblock is an idempotent operation that withdraws the block of userId by blockerId
+func (db *DB) Unblock(blockerId, userId int) error {
+	return db.Update(func(tx *Tx) error {
+		blocked := tx.Blocked(blockerId)
+		i := slices.Index(blocked, userId)
+		if i == -1 {
+			return nil
+		}
+		return tx.PutBlocked(blockerId, slices.Delete(blocked, i, i+1))
+	})
+}
+
+// Mute is an idempotent operation that hides the chirps of userId from muterId
+func (db *DB) Mute(muterId, userId int) error {
+	if muterId == userId {
+		return ErrSelfBlock
+	}
+	return db.Update(func(tx *Tx) error {
+		if _, found := tx.User(userId); !found {
+			return ErrUserNotFound
+		}
+		muted := tx.Muted(muterId)
+		if slices.Contains(muted, userId) {
+			return nil
+		}
+		return tx.PutMuted(muterId, append(muted, userId))
+	})
+}
+
+// Unmute is an idempotent operation that withdraws the mute of userId by muterId
+func (db *DB) Unmute(muterId, userId int) error {
+	return db.Update(func(tx *Tx) error {
+		muted := tx.Muted(muterId)
+		i := slices.Index(muted, userId)
+		if i == -1 {
+			return nil
+		}
+		return tx.PutMuted(muterId, slices.Delete(muted, i, i+1))
+	})
+}
+
+// GetBlocked returns the users blocked by userId
+func (db *DB) GetBlocked(userId int) ([]entities.User, error) {
+	return db.usersByIds(userId, (*Tx).Blocked)
+}
+
+// GetMuted returns the users muted by userId
+func (db *DB) GetMuted(userId int) ([]entities.User, error) {
+	return db.usersByIds(userId, (*Tx).Muted)
+}
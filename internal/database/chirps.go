@@ -1,49 +1,280 @@
 package database
 
-import "github.com/sp3dr4/chirpy/internal/entities"
+import (
+	"errors"
+	"time"
 
-// CreateChirp creates a new chirp and saves it to disk
-func (db *DB) CreateChirp(userId int, body string) (*entities.Chirp, error) {
-	db.chirpIdMux.Lock()
-	db.chirpLastId += 1
-	db.chirpIdMux.Unlock()
-	chirp := entities.Chirp{Id: db.chirpLastId, Body: body, UserId: userId}
-	dbObj, err := db.loadDB()
+	"github.com/sp3dr4/chirpy/internal/entities"
+	"github.com/sp3dr4/chirpy/internal/search"
+)
+
+var ErrChirpNotFound = errors.New("chirp not found")
+var ErrParentNotFound = errors.New("replied chirp not found")
+var ErrEmptySearch = errors.New("search query has no terms")
+var ErrNoRecipients = errors.New("direct chirps must mention at least one user")
+
+// CreateChirp saves a new chirp, assigning its id, timestamps and,
+// for replies, the root of the conversation. Flagged chirps are
+// queued for review. Chirps are public unless set otherwise, direct
+// ones must mention a known user.
+func (db *DB) CreateChirp(chirp entities.Chirp) (*entities.Chirp, error) {
+	err := db.Update(func(tx *Tx) error {
+		if chirp.Visibility == "" {
+			chirp.Visibility = entities.VisibilityPublic
+		}
+		chirp.RootId = nil
+		if chirp.InReplyTo != nil {
+			parent, found := tx.Chirp(*chirp.InReplyTo)
+			if !found || !tx.Readable(parent, &chirp.UserId) {
+				return ErrParentNotFound
+			}
+			if tx.Blocks(parent.UserId, chirp.UserId) {
+				return ErrBlocked
+			}
+			rootId := parent.ThreadRootId()
+			chirp.RootId = &rootId
+		}
+		chirp.Hashtags = entities.ParseHashtags(chirp.Body)
+		chirp.Mentions = resolveMentions(chirp.Body, tx.userIdByHandle)
+		if chirp.Visibility == entities.VisibilityDirect && len(chirp.Mentions) == 0 {
+			return ErrNoRecipients
+		}
+		now := time.Now().UTC()
+		chirp.Id = tx.NextChirpId()
+		chirp.CreatedAt = now
+		chirp.UpdatedAt = now
+		if err := tx.PutChirp(chirp); err != nil {
+			return err
+		}
+		return fileFlagReport(tx, chirp)
+	})
 	if err != nil {
 		return nil, err
 	}
-	dbObj.Chirps[chirp.Id] = chirp
-	if err = db.writeDB(*dbObj); err != nil {
-		return nil, err
-	}
 	return &chirp, nil
 }
 
+// resolveMentions returns the users @mentioned in text,
+// skipping the handles that lookup does not know
+func resolveMentions(text string, lookup func(handle string) (int, bool)) []entities.Mention {
+	mentions := []entities.Mention{}
+	for _, handle := range entities.ParseMentions(text) {
+		if userId, found := lookup(handle); found {
+			mentions = append(mentions, entities.Mention{Handle: handle, UserId: userId})
+		}
+	}
+	return mentions
+}
+
 // GetChirps returns all chirps in the database
 func (db *DB) GetChirps(userId *int) ([]entities.Chirp, error) {
-	dbObj, err := db.loadDB()
+	var chirps []entities.Chirp
+	err := db.View(func(tx *Tx) error {
+		if userId != nil {
+			chirps = tx.ChirpsByAuthor(*userId)
+		} else {
+			chirps = tx.Chirps()
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	chirps := make([]entities.Chirp, 0, len(dbObj.Chirps))
-	for _, value := range dbObj.Chirps {
-		if userId == nil || *userId == value.UserId {
-			chirps = append(chirps, value)
-		}
+	return chirps, nil
+}
+
+// ChirpQuery selects a page of chirps in id order
+type ChirpQuery struct {
+	AuthorId *int
+	// Hashtag is ignored when AuthorId is set
+	Hashtag string
+	Desc    bool
+	// AfterId is the last chirp of the previous page, 0 for the first page
+	AfterId int
+	Limit   int
+	// ViewerId is who reads the page, chirps they may not read are
+	// left out, see Tx.ChirpFilter
+	ViewerId *int
+	// Inbox selects the direct chirps addressed to ViewerId,
+	// ignoring AuthorId and Hashtag
+	Inbox bool
+}
+
+// ListChirps returns the page of chirps selected by q
+// and whether more chirps follow it
+func (db *DB) ListChirps(q ChirpQuery) ([]entities.Chirp, bool, error) {
+	var chirps []entities.Chirp
+	var more bool
+	err := db.View(func(tx *Tx) error {
+		chirps, more = tx.ChirpsPage(q)
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return chirps, more, nil
+}
+
+// SearchQuery selects chirps by full-text search.
+// Text holds bare terms and double-quoted phrases, all of which must match.
+// ViewerId filters chirps out like ChirpQuery.ViewerId.
+type SearchQuery struct {
+	Text     string
+	AuthorId *int
+	Limit    int
+	ViewerId *int
+}
+
+// SearchChirps returns up to q.Limit chirps matching q, most relevant first
+func (db *DB) SearchChirps(q SearchQuery) ([]entities.Chirp, error) {
+	parsed := search.ParseQuery(q.Text)
+	if parsed.IsEmpty() {
+		return nil, ErrEmptySearch
+	}
+	var chirps []entities.Chirp
+	err := db.View(func(tx *Tx) error {
+		chirps = tx.SearchChirps(parsed, q.AuthorId, q.ViewerId, q.Limit)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return chirps, nil
 }
 
-// DeleteChirp is an idempotent operation that deletes a chirp by id.
-func (db *DB) DeleteChirp(id int) error {
-	dbObj, err := db.loadDB()
+// GetChirpByID returns the chirp with the given id
+func (db *DB) GetChirpByID(id int) (*entities.Chirp, error) {
+	var chirp entities.Chirp
+	err := db.View(func(tx *Tx) error {
+		var found bool
+		if chirp, found = tx.Chirp(id); !found {
+			return ErrChirpNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &chirp, nil
+}
+
+// GetVisibleChirp returns the chirp with the given id if viewerId may read
+// it. Authors always find their own chirps, even hidden ones.
+func (db *DB) GetVisibleChirp(id int, viewerId *int) (*entities.Chirp, error) {
+	var chirp entities.Chirp
+	err := db.View(func(tx *Tx) error {
+		var found bool
+		if chirp, found = tx.Chirp(id); !found {
+			return ErrChirpNotFound
+		}
+		if viewerId != nil && *viewerId == chirp.UserId {
+			return nil
+		}
+		if !tx.ChirpFilter(viewerId).Allows(chirp) {
+			return ErrChirpNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &chirp, nil
+}
+
+// UpdateChirp replaces the body of a chirp and its moderation flags,
+// keeping the previous body in its history. Flagged chirps are
+// queued for review. Direct chirps must keep mentioning a known user.
+func (db *DB) UpdateChirp(id int, body string, flags []string) (*entities.Chirp, error) {
+	var chirp entities.Chirp
+	err := db.Update(func(tx *Tx) error {
+		var found bool
+		if chirp, found = tx.Chirp(id); !found {
+			return ErrChirpNotFound
+		}
+		now := time.Now().UTC()
+		revisions := append(tx.ChirpRevisions(id), entities.ChirpRevision{
+			ChirpId:  id,
+			Body:     chirp.Body,
+			PostedAt: chirp.UpdatedAt,
+			EditedAt: now,
+		})
+		if err := tx.PutChirpRevisions(id, revisions); err != nil {
+			return err
+		}
+		chirp.Body = body
+		chirp.Flags = flags
+		chirp.Hashtags = entities.ParseHashtags(body)
+		chirp.Mentions = resolveMentions(body, tx.userIdByHandle)
+		if chirp.Visibility == entities.VisibilityDirect && len(chirp.Mentions) == 0 {
+			return ErrNoRecipients
+		}
+		chirp.UpdatedAt = now
+		if err := tx.PutChirp(chirp); err != nil {
+			return err
+		}
+		return fileFlagReport(tx, chirp)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &chirp, nil
+}
+
+// GetChirpHistory returns the previous bodies of a chirp, oldest first
+func (db *DB) GetChirpHistory(id int) ([]entities.ChirpRevision, error) {
+	var revisions []entities.ChirpRevision
+	err := db.View(func(tx *Tx) error {
+		if _, found := tx.Chirp(id); !found {
+			return ErrChirpNotFound
+		}
+		revisions = tx.ChirpRevisions(id)
+		if revisions == nil {
+			revisions = []entities.ChirpRevision{}
+		}
+		return nil
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	delete(dbObj.Chirps, id)
-	if err = db.writeDB(*dbObj); err != nil {
-		return err
+	return revisions, nil
+}
+
+// GetThread returns every chirp of the conversation started by rootId
+// that viewerId may read, including the root unless it was deleted
+func (db *DB) GetThread(rootId int, viewerId *int) ([]entities.Chirp, error) {
+	var chirps []entities.Chirp
+	err := db.View(func(tx *Tx) error {
+		chirps = tx.ThreadReplies(rootId, viewerId)
+		if root, found := tx.Chirp(rootId); found && tx.ChirpFilter(viewerId).Allows(root) {
+			chirps = append(chirps, root)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return chirps, nil
+}
 
-	return nil
+// DeleteChirp is an idempotent operation that deletes a chirp by id.
+func (db *DB) DeleteChirp(id int) error {
+	return db.Update(func(tx *Tx) error {
+		if _, exists := tx.Chirp(id); !exists {
+			return nil
+		}
+		if len(tx.ChirpRevisions(id)) > 0 {
+			if err := tx.DeleteChirpRevisions(id); err != nil {
+				return err
+			}
+		}
+		if err := tx.PutLikes(id, nil); err != nil {
+			return err
+		}
+		for _, r := range tx.ChirpRechirps(id) {
+			if err := tx.DeleteRechirp(r.Id); err != nil {
+				return err
+			}
+		}
+		return tx.DeleteChirp(id)
+	})
 }
@@ -4,68 +4,249 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"os"
+	"path/filepath"
 	"sync"
 
 	"github.com/sp3dr4/chirpy/internal/entities"
 )
 
 var ErrDuplicateUser = fmt.Errorf("user with email already exists")
+var ErrDatabaseInUse = errors.New("database is in use by another process")
 
 type DB struct {
-	debug bool
-
-	mux  *sync.RWMutex
+	// mux guards the whole content of the database, see View and Update
+	mux *sync.RWMutex
+	// path is empty when the database only lives in memory
 	path string
+	// lock is held on the lock file next to path for as long as the
+	// database is open, so that a single process writes the files
+	lock *os.File
+	data *DBStructure
+	idx  indexes
+	// journaled counts the commits appended since the last compaction
+	journaled int
 
-	chirpIdMux  *sync.RWMutex
-	chirpLastId int
-
-	userIdMux  *sync.RWMutex
-	userLastId int
+	chirpLastId   int
+	userLastId    int
+	rechirpLastId int
+	reportLastId  int
+	sessionLastId int
+	eventLastId   int
 }
 
 type DBStructure struct {
-	Chirps        map[int]entities.Chirp        `json:"chirps"`
-	Users         map[int]entities.User         `json:"users"`
-	RefreshTokens map[int]entities.RefreshToken `json:"tokens"`
+	Version  int                      `json:"version"`
+	Chirps   map[int]entities.Chirp   `json:"chirps"`
+	Users    map[int]entities.User    `json:"users"`
+	Sessions map[int]entities.Session `json:"sessions"`
+	// ChirpRevisions holds the previous bodies of edited chirps, oldest first
+	ChirpRevisions map[int][]entities.ChirpRevision `json:"chirp_revisions"`
+	// Follows maps a user id to the ids of the users they follow
+	Follows map[int][]int `json:"follows"`
+	// Likes maps a chirp id to the ids of the users who liked it
+	Likes    map[int][]int            `json:"likes"`
+	Rechirps map[int]entities.Rechirp `json:"rechirps"`
+	Reports  map[int]entities.Report  `json:"reports"`
+	// Blocks and Mutes map a user id to the ids of the users they block or mute
+	Blocks map[int][]int `json:"blocks"`
+	Mutes  map[int][]int `json:"mutes"`
+
+	SecurityEvents map[int]entities.SecurityEvent `json:"security_events"`
+	// RevokedTokens is the denylist of the access tokens revoked before
+	// they expire, keyed by jti
+	RevokedTokens map[string]entities.AccessToken `json:"revoked_tokens"`
+}
+
+func newDBStructure() DBStructure {
+	return DBStructure{
+		Version:  SchemaVersion(),
+		Chirps:   map[int]entities.Chirp{},
+		Users:    map[int]entities.User{},
+		Sessions: map[int]entities.Session{},
+
+		ChirpRevisions: map[int][]entities.ChirpRevision{},
+		Follows:        map[int][]int{},
+		Likes:          map[int][]int{},
+		Rechirps:       map[int]entities.Rechirp{},
+		Reports:        map[int]entities.Report{},
+		Blocks:         map[int][]int{},
+		Mutes:          map[int][]int{},
+		SecurityEvents: map[int]entities.SecurityEvent{},
+		RevokedTokens:  map[string]entities.AccessToken{},
+	}
+}
+
+// NewMemoryDB creates a database that only lives in memory,
+// useful for tests and ephemeral dev servers
+func NewMemoryDB() *DB {
+	data := newDBStructure()
+	return &DB{
+		mux:  &sync.RWMutex{},
+		data: &data,
+		idx:  newIndexes(),
+	}
 }
 
 // NewDB creates a new database connection
-// and creates the database file if it doesn't exist
-func NewDB(path string, debug bool) (*DB, error) {
+// and creates the database file if it doesn't exist.
+// With reset, any existing data is discarded first.
+// It fails with ErrDatabaseInUse while another process has it open.
+func NewDB(path string, reset bool) (*DB, error) {
+	lock, err := lockFile(path + ".lock")
+	if err != nil {
+		return nil, err
+	}
 	db := &DB{
-		debug:       debug,
-		mux:         &sync.RWMutex{},
-		path:        path,
-		chirpIdMux:  &sync.RWMutex{},
-		chirpLastId: 0,
-		userIdMux:   &sync.RWMutex{},
-		userLastId:  0,
-	}
-	if db.debug {
-		if err := os.Remove(db.path); err != nil {
-			return nil, err
+		mux:  &sync.RWMutex{},
+		path: path,
+		lock: lock,
+	}
+	if err := db.open(reset); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *DB) open(reset bool) error {
+	if reset {
+		if err := removeFiles(db.path, db.journalPath()); err != nil {
+			return err
 		}
 	}
 	if err := db.ensureDB(); err != nil {
-		return nil, err
+		return err
+	}
+	migrated, err := Migrate(db.path, false)
+	if err != nil {
+		return err
+	}
+	if len(migrated.Applied) > 0 {
+		fmt.Printf("migrated database from version %d to %d, backup in %s\n", migrated.From, migrated.To, migrated.Backup)
+	}
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	if err := db.loadDB(); err != nil {
+		return err
+	}
+	if err := db.recoverJournal(); err != nil {
+		return err
+	}
+
+	db.resetCounters()
+
+	return nil
+}
+
+// Close releases the lock on the database files, the database
+// must not be used afterwards
+func (db *DB) Close() error {
+	if db.lock == nil {
+		return nil
+	}
+	return db.lock.Close()
+}
+
+// ReadDB loads the database at path, with the commits pending in its
+// journal, into a database that only lives in memory. The files are
+// neither locked, migrated nor compacted, so that a server can keep
+// running on them, and changes to the returned database are not saved.
+func ReadDB(path string) (*DB, error) {
+	for {
+		before, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		dat, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		journal, err := os.ReadFile(path + ".journal")
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		after, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		// a compaction replaced the snapshot while the journal was
+		// read, which may then miss commits: read both again
+		if !os.SameFile(before, after) {
+			continue
+		}
+		return readDocument(dat, journal)
 	}
+}
 
-	dbObj, err := db.loadDB()
+// readDocument builds a memory database out of a snapshot and its journal
+// of any schema version, the way Migrate would upgrade them
+func readDocument(dat, journal []byte) (*DB, error) {
+	doc := map[string]json.RawMessage{}
+	if err := json.Unmarshal(dat, &doc); err != nil {
+		return nil, err
+	}
+	version, err := documentVersion(doc)
 	if err != nil {
 		return nil, err
 	}
+	if err := replayRawJournal(doc, journal); err != nil {
+		return nil, err
+	}
+	if _, err := upgradeDocument(doc, version); err != nil {
+		return nil, err
+	}
+	upgraded, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	dbObj := &DBStructure{}
+	if err := json.Unmarshal(upgraded, dbObj); err != nil {
+		return nil, err
+	}
+	db := &DB{
+		mux:  &sync.RWMutex{},
+		data: dbObj,
+		idx:  buildIndexes(dbObj),
+	}
+	db.resetCounters()
+	return db, nil
+}
 
-	for cid := range dbObj.Chirps {
+// resetCounters derives the last used ids from the resident data.
+// Callers must hold db.mux for writing.
+func (db *DB) resetCounters() {
+	db.chirpLastId = 0
+	for cid := range db.data.Chirps {
 		db.chirpLastId = max(db.chirpLastId, cid)
 	}
 
-	for uid := range dbObj.Users {
+	db.userLastId = 0
+	for uid := range db.data.Users {
 		db.userLastId = max(db.userLastId, uid)
 	}
 
-	return db, nil
+	db.rechirpLastId = 0
+	for rid := range db.data.Rechirps {
+		db.rechirpLastId = max(db.rechirpLastId, rid)
+	}
+
+	db.reportLastId = 0
+	for rid := range db.data.Reports {
+		db.reportLastId = max(db.reportLastId, rid)
+	}
+
+	db.sessionLastId = 0
+	for sid := range db.data.Sessions {
+		db.sessionLastId = max(db.sessionLastId, sid)
+	}
+
+	db.eventLastId = 0
+	for eid := range db.data.SecurityEvents {
+		db.eventLastId = max(db.eventLastId, eid)
+	}
 }
 
 // ensureDB creates a new database file if it doesn't exist
@@ -77,44 +258,103 @@ func (db *DB) ensureDB() error {
 	if !errors.Is(err, os.ErrNotExist) {
 		return err
 	}
-	dbObj := DBStructure{
-		Chirps:        map[int]entities.Chirp{},
-		Users:         map[int]entities.User{},
-		RefreshTokens: map[int]entities.RefreshToken{},
-	}
-	if err = db.writeDB(dbObj); err != nil {
-		return err
-	}
-	return nil
+	return db.writeSnapshot(newDBStructure())
 }
 
-// loadDB reads the database file into memory
-func (db *DB) loadDB() (*DBStructure, error) {
-	db.mux.RLock()
-	defer db.mux.RUnlock()
+// loadDB reads the database file into memory and builds the indexes.
+// Callers must hold db.mux for writing.
+func (db *DB) loadDB() error {
 	dat, err := os.ReadFile(db.path)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	dbstruct := &DBStructure{}
 	if err = json.Unmarshal(dat, dbstruct); err != nil {
-		return nil, err
+		return err
 	}
-	return dbstruct, nil
+	db.data = dbstruct
+	db.idx = buildIndexes(dbstruct)
+	return nil
 }
 
-// writeDB writes the database file to disk
-func (db *DB) writeDB(dbStructure DBStructure) error {
-	db.mux.Lock()
-	defer db.mux.Unlock()
+// persist makes entries, already applied to the resident data, durable.
+// They are appended to the journal and folded into the snapshot
+// every compactEvery commits. Callers must hold db.mux for writing.
+func (db *DB) persist(entries []journalEntry) error {
+	if db.path == "" {
+		return nil
+	}
+	if err := db.appendJournal(entries); err != nil {
+		return err
+	}
+	db.journaled += 1
+	if db.journaled < compactEvery {
+		return nil
+	}
+	if err := db.compact(); err != nil {
+		// the commit is already durable in the journal,
+		// compaction is attempted again on the next one
+		log.Printf("journal compaction failed: %v", err)
+	}
+	return nil
+}
+
+// writeSnapshot atomically replaces the database file: the data is written
+// to a temporary file in the same directory, synced, then renamed over it
+func (db *DB) writeSnapshot(dbStructure DBStructure) error {
 	dat, err := json.Marshal(dbStructure)
 	if err != nil {
 		return err
 	}
-	err = os.WriteFile(db.path, dat, 0640)
+	return db.writeRawSnapshot(dat)
+}
+
+func (db *DB) writeRawSnapshot(dat []byte) error {
+	dir := filepath.Dir(db.path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(db.path)+".tmp-*")
 	if err != nil {
+		return err
+	}
+	// no-op once the rename succeeded
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(dat); err != nil {
+		tmp.Close()
 		fmt.Printf("WriteFile err: %v\n", err)
 		return err
 	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0640); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), db.path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// removeFiles deletes the given files, ignoring the ones that do not exist
+func removeFiles(paths ...string) error {
+	for _, p := range paths {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
 	return nil
 }
+
+// syncDir makes a rename in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
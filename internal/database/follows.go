@@ -0,0 +1,133 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"sort"
+
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+var ErrSelfFollow = errors.New("users cannot follow themselves")
+
+// Follow is an idempotent operation that makes followerId follow followeeId
+func (db *DB) Follow(followerId, followeeId int) error {
+	if followerId == followeeId {
+		return ErrSelfFollow
+	}
+	return db.Update(func(tx *Tx) error {
+		if _, found := tx.User(followeeId); !found {
+			return ErrUserNotFound
+		}
+		if tx.Blocks(followeeId, followerId) {
+			return ErrBlocked
+		}
+		following := tx.Following(followerId)
+		if slices.Contains(following, followeeId) {
+			return nil
+		}
+		return tx.PutFollowing(followerId, append(following, followeeId))
+	})
+}
+
+// Unfollow is an idempotent operation that makes followerId stop following followeeId
+func (db *DB) Unfollow(followerId, followeeId int) error {
+	return db.Update(func(tx *Tx) error {
+		following := tx.Following(followerId)
+		i := slices.Index(following, followeeId)
+		if i == -1 {
+			return nil
+		}
+		return tx.PutFollowing(followerId, slices.Delete(following, i, i+1))
+	})
+}
+
+// GetFollowers returns the users following userId
+func (db *DB) GetFollowers(userId int) ([]entities.User, error) {
+	return db.usersByIds(userId, (*Tx).Followers)
+}
+
+// GetFollowing returns the users followed by userId
+func (db *DB) GetFollowing(userId int) ([]entities.User, error) {
+	return db.usersByIds(userId, (*Tx).Following)
+}
+
+func (db *DB) usersByIds(userId int, idsFn func(tx *Tx, userId int) []int) ([]entities.User, error) {
+	var users []entities.User
+	err := db.View(func(tx *Tx) error {
+		if _, found := tx.User(userId); !found {
+			return ErrUserNotFound
+		}
+		users = []entities.User{}
+		for _, id := range idsFn(tx, userId) {
+			if u, found := tx.User(id); found {
+				users = append(users, u)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// GetTimeline returns the chirps readable by userId posted or rechirped
+// by the users they follow, newest first, leaving out the followees
+// excluded for userId. A chirp appears once, at its latest entry.
+func (db *DB) GetTimeline(userId int) ([]entities.TimelineItem, error) {
+	var items []entities.TimelineItem
+	err := db.View(func(tx *Tx) error {
+		items = []entities.TimelineItem{}
+		filter := tx.ChirpFilter(&userId)
+		for _, followeeId := range tx.Following(userId) {
+			if _, skip := filter.excluded[followeeId]; skip {
+				continue
+			}
+			for _, c := range tx.ChirpsByAuthor(followeeId) {
+				if filter.Allows(c) {
+					items = append(items, entities.TimelineItem{Chirp: c})
+				}
+			}
+			for _, r := range tx.UserRechirps(followeeId) {
+				if c, found := tx.Chirp(r.ChirpId); found && filter.Allows(c) {
+					items = append(items, entities.TimelineItem{Chirp: c, Rechirp: &r})
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return latestTimelineItems(items), nil
+}
+
+// latestTimelineItems keeps the latest item of every chirp, newest first
+func latestTimelineItems(items []entities.TimelineItem) []entities.TimelineItem {
+	latest := map[int]entities.TimelineItem{}
+	for _, item := range items {
+		if prev, ok := latest[item.Chirp.Id]; !ok || item.At().After(prev.At()) {
+			latest[item.Chirp.Id] = item
+		}
+	}
+	items = make([]entities.TimelineItem, 0, len(latest))
+	for _, item := range latest {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].At().Equal(items[j].At()) {
+			return items[i].At().After(items[j].At())
+		}
+		return newerChirp(items[i].Chirp, items[j].Chirp)
+	})
+	return items
+}
+
+// newerChirp orders chirps newest first, ids breaking ties
+func newerChirp(a, b entities.Chirp) bool {
+	if a.CreatedAt.Equal(b.CreatedAt) {
+		return a.Id > b.Id
+	}
+	return a.CreatedAt.After(b.CreatedAt)
+}
@@ -0,0 +1,21 @@
+package database
+
+import (
+	"time"
+
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+// TrendingHashtags returns the limit hashtags used by the most chirps
+// created since the given time, most used first
+func (db *DB) TrendingHashtags(since time.Time, limit int) ([]entities.HashtagCount, error) {
+	var trending []entities.HashtagCount
+	err := db.View(func(tx *Tx) error {
+		trending = tx.TrendingHashtags(since, limit)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return trending, nil
+}
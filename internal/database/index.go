@@ -0,0 +1,257 @@
+package database
+
+import (
+	"slices"
+
+	"github.com/sp3dr4/chirpy/internal/entities"
+	"github.com/sp3dr4/chirpy/internal/search"
+)
+
+// indexes are secondary lookups over the resident DBStructure.
+// They are never persisted and are rebuilt when the database is loaded.
+type indexes struct {
+	userByEmail  map[string]int
+	userByHandle map[string]int
+	// sessionByToken finds a session by the hash of its refresh token
+	sessionByToken map[string]int
+	// sessionByRetiredToken finds a session by the hash of a refresh
+	// token it rotated out
+	sessionByRetiredToken map[string]int
+	sessionsByUser        map[int]map[int]struct{}
+	eventsByUser          map[int]map[int]struct{}
+	// chirpIds and chirpsByAuthor are sorted, for paginated scans
+	chirpIds       []int
+	chirpsByAuthor map[int][]int
+	repliesByRoot  map[int]map[int]struct{}
+	// chirpsByHashtag is sorted like chirpsByAuthor
+	chirpsByHashtag map[string][]int
+	// directByRecipient holds the sorted ids of the direct chirps
+	// mentioning a user, their inbox
+	directByRecipient map[int][]int
+	// followers is the reverse of DBStructure.Follows
+	followers map[int]map[int]struct{}
+	// blockedBy is the reverse of DBStructure.Blocks
+	blockedBy map[int]map[int]struct{}
+	// likesByUser is the reverse of DBStructure.Likes
+	likesByUser map[int]map[int]struct{}
+	// rechirpsByChirp and rechirpsByUser hold rechirp ids,
+	// rechirpByUserChirp finds the rechirp of a chirp by a user
+	rechirpsByChirp    map[int]map[int]struct{}
+	rechirpsByUser     map[int]map[int]struct{}
+	rechirpByUserChirp map[[2]int]int
+	// reportsByChirp and reportsByUser hold report ids
+	reportsByChirp map[int]map[int]struct{}
+	reportsByUser  map[int]map[int]struct{}
+	// chirpText is the full-text index over chirp bodies
+	chirpText *search.Index
+}
+
+func newIndexes() indexes {
+	return indexes{
+		userByEmail:       map[string]int{},
+		userByHandle:      map[string]int{},
+		chirpIds:          []int{},
+		chirpsByAuthor:    map[int][]int{},
+		repliesByRoot:     map[int]map[int]struct{}{},
+		chirpsByHashtag:   map[string][]int{},
+		directByRecipient: map[int][]int{},
+		followers:         map[int]map[int]struct{}{},
+		likesByUser:       map[int]map[int]struct{}{},
+		blockedBy:         map[int]map[int]struct{}{},
+
+		sessionByToken:        map[string]int{},
+		sessionByRetiredToken: map[string]int{},
+		sessionsByUser:        map[int]map[int]struct{}{},
+		eventsByUser:          map[int]map[int]struct{}{},
+
+		rechirpsByChirp:    map[int]map[int]struct{}{},
+		rechirpsByUser:     map[int]map[int]struct{}{},
+		rechirpByUserChirp: map[[2]int]int{},
+		reportsByChirp:     map[int]map[int]struct{}{},
+		reportsByUser:      map[int]map[int]struct{}{},
+		chirpText:          search.NewIndex(),
+	}
+}
+
+func buildIndexes(dbObj *DBStructure) indexes {
+	idx := newIndexes()
+	// adding chirps in id order keeps every sorted insert an append
+	chirpIds := make([]int, 0, len(dbObj.Chirps))
+	for id := range dbObj.Chirps {
+		chirpIds = append(chirpIds, id)
+	}
+	slices.Sort(chirpIds)
+	for _, id := range chirpIds {
+		idx.addChirp(dbObj.Chirps[id])
+	}
+	for _, u := range dbObj.Users {
+		idx.addUser(u)
+	}
+	for _, s := range dbObj.Sessions {
+		idx.addSession(s)
+	}
+	for _, e := range dbObj.SecurityEvents {
+		idx.addEvent(e)
+	}
+	for from, targets := range dbObj.Follows {
+		for _, to := range targets {
+			addToSet(idx.followers, to, from)
+		}
+	}
+	for from, targets := range dbObj.Blocks {
+		for _, to := range targets {
+			addToSet(idx.blockedBy, to, from)
+		}
+	}
+	for chirpId, userIds := range dbObj.Likes {
+		for _, userId := range userIds {
+			addToSet(idx.likesByUser, userId, chirpId)
+		}
+	}
+	for _, r := range dbObj.Rechirps {
+		idx.addRechirp(r)
+	}
+	for _, r := range dbObj.Reports {
+		idx.addReport(r)
+	}
+	return idx
+}
+
+// insertSorted adds id to the sorted ids, unless already there
+func insertSorted(ids []int, id int) []int {
+	i, found := slices.BinarySearch(ids, id)
+	if found {
+		return ids
+	}
+	return slices.Insert(ids, i, id)
+}
+
+// removeSorted removes id from the sorted ids
+func removeSorted(ids []int, id int) []int {
+	i, found := slices.BinarySearch(ids, id)
+	if !found {
+		return ids
+	}
+	return slices.Delete(ids, i, i+1)
+}
+
+// addToSet adds id to the set stored under key in m
+func addToSet(m map[int]map[int]struct{}, key, id int) {
+	set, ok := m[key]
+	if !ok {
+		set = map[int]struct{}{}
+		m[key] = set
+	}
+	set[id] = struct{}{}
+}
+
+// removeFromSet removes id from the set stored under key in m
+func removeFromSet(m map[int]map[int]struct{}, key, id int) {
+	delete(m[key], id)
+	if len(m[key]) == 0 {
+		delete(m, key)
+	}
+}
+
+func (idx *indexes) addChirp(c entities.Chirp) {
+	idx.chirpIds = insertSorted(idx.chirpIds, c.Id)
+	idx.chirpsByAuthor[c.UserId] = insertSorted(idx.chirpsByAuthor[c.UserId], c.Id)
+	if c.RootId != nil {
+		addToSet(idx.repliesByRoot, *c.RootId, c.Id)
+	}
+	for _, tag := range c.Hashtags {
+		idx.chirpsByHashtag[tag] = insertSorted(idx.chirpsByHashtag[tag], c.Id)
+	}
+	if c.Visibility == entities.VisibilityDirect {
+		for _, m := range c.Mentions {
+			idx.directByRecipient[m.UserId] = insertSorted(idx.directByRecipient[m.UserId], c.Id)
+		}
+	}
+	idx.chirpText.Add(c.Id, c.Body)
+}
+
+func (idx *indexes) removeChirp(c entities.Chirp) {
+	idx.chirpIds = removeSorted(idx.chirpIds, c.Id)
+	idx.chirpsByAuthor[c.UserId] = removeSorted(idx.chirpsByAuthor[c.UserId], c.Id)
+	if len(idx.chirpsByAuthor[c.UserId]) == 0 {
+		delete(idx.chirpsByAuthor, c.UserId)
+	}
+	if c.RootId != nil {
+		removeFromSet(idx.repliesByRoot, *c.RootId, c.Id)
+	}
+	for _, tag := range c.Hashtags {
+		idx.chirpsByHashtag[tag] = removeSorted(idx.chirpsByHashtag[tag], c.Id)
+		if len(idx.chirpsByHashtag[tag]) == 0 {
+			delete(idx.chirpsByHashtag, tag)
+		}
+	}
+	if c.Visibility == entities.VisibilityDirect {
+		for _, m := range c.Mentions {
+			idx.directByRecipient[m.UserId] = removeSorted(idx.directByRecipient[m.UserId], c.Id)
+			if len(idx.directByRecipient[m.UserId]) == 0 {
+				delete(idx.directByRecipient, m.UserId)
+			}
+		}
+	}
+	idx.chirpText.Remove(c.Id)
+}
+
+func (idx *indexes) addUser(u entities.User) {
+	idx.userByEmail[u.Email] = u.Id
+	idx.userByHandle[u.Handle] = u.Id
+}
+
+func (idx *indexes) removeUser(u entities.User) {
+	delete(idx.userByEmail, u.Email)
+	delete(idx.userByHandle, u.Handle)
+}
+
+func (idx *indexes) addSession(s entities.Session) {
+	idx.sessionByToken[s.TokenHash] = s.Id
+	for _, hash := range s.RetiredHashes {
+		idx.sessionByRetiredToken[hash] = s.Id
+	}
+	addToSet(idx.sessionsByUser, s.UserId, s.Id)
+}
+
+func (idx *indexes) removeSession(s entities.Session) {
+	delete(idx.sessionByToken, s.TokenHash)
+	for _, hash := range s.RetiredHashes {
+		delete(idx.sessionByRetiredToken, hash)
+	}
+	removeFromSet(idx.sessionsByUser, s.UserId, s.Id)
+}
+
+func (idx *indexes) addEvent(e entities.SecurityEvent) {
+	addToSet(idx.eventsByUser, e.UserId, e.Id)
+}
+
+func (idx *indexes) removeEvent(e entities.SecurityEvent) {
+	removeFromSet(idx.eventsByUser, e.UserId, e.Id)
+}
+
+func (idx *indexes) addRechirp(r entities.Rechirp) {
+	addToSet(idx.rechirpsByChirp, r.ChirpId, r.Id)
+	addToSet(idx.rechirpsByUser, r.UserId, r.Id)
+	idx.rechirpByUserChirp[[2]int{r.UserId, r.ChirpId}] = r.Id
+}
+
+func (idx *indexes) removeRechirp(r entities.Rechirp) {
+	removeFromSet(idx.rechirpsByChirp, r.ChirpId, r.Id)
+	removeFromSet(idx.rechirpsByUser, r.UserId, r.Id)
+	delete(idx.rechirpByUserChirp, [2]int{r.UserId, r.ChirpId})
+}
+
+func (idx *indexes) addReport(r entities.Report) {
+	if r.ChirpId != nil {
+		addToSet(idx.reportsByChirp, *r.ChirpId, r.Id)
+	}
+	addToSet(idx.reportsByUser, r.UserId, r.Id)
+}
+
+func (idx *indexes) removeReport(r entities.Report) {
+	if r.ChirpId != nil {
+		removeFromSet(idx.reportsByChirp, *r.ChirpId, r.Id)
+	}
+	removeFromSet(idx.reportsByUser, r.UserId, r.Id)
+}
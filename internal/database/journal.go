@@ -0,0 +1,306 @@
+package database
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+const (
+	opPutChirp      = "chirp.put"
+	opDeleteChirp   = "chirp.delete"
+	opPutUser       = "user.put"
+	opDeleteUser    = "user.delete"
+	opPutSession    = "session.put"
+	opDeleteSession = "session.delete"
+
+	opPutRevisions    = "revisions.put"
+	opDeleteRevisions = "revisions.delete"
+	opPutFollows      = "follows.put"
+	opDeleteFollows   = "follows.delete"
+	opPutLikes        = "likes.put"
+	opDeleteLikes     = "likes.delete"
+	opPutRechirp      = "rechirp.put"
+	opDeleteRechirp   = "rechirp.delete"
+	opPutReport       = "report.put"
+	opDeleteReport    = "report.delete"
+	opPutBlocks       = "blocks.put"
+	opDeleteBlocks    = "blocks.delete"
+	opPutMutes        = "mutes.put"
+	opDeleteMutes     = "mutes.delete"
+	opPutEvent        = "event.put"
+	opDeleteEvent     = "event.delete"
+	opPutRevoked      = "revoked.put"
+	opDeleteRevoked   = "revoked.delete"
+)
+
+// compactEvery is the number of journaled commits after which
+// the snapshot is rewritten and the journal truncated
+const compactEvery = 64
+
+// journalEntry is a single mutation recorded in the write-ahead journal.
+// Entries are idempotent so replaying one already in the snapshot is harmless.
+type journalEntry struct {
+	Op      string            `json:"op"`
+	Id      int               `json:"id,omitempty"`
+	Chirp   *entities.Chirp   `json:"chirp,omitempty"`
+	User    *entities.User    `json:"user,omitempty"`
+	Session *entities.Session `json:"session,omitempty"`
+
+	Rechirp *entities.Rechirp `json:"rechirp,omitempty"`
+	Report  *entities.Report  `json:"report,omitempty"`
+
+	Event *entities.SecurityEvent `json:"event,omitempty"`
+	// Revoked is keyed by its jti, it is carried by deletes as well
+	Revoked *entities.AccessToken `json:"revoked,omitempty"`
+
+	Revisions []entities.ChirpRevision `json:"revisions,omitempty"`
+	// Ids are the targets of a relation such as follows, keyed by Id
+	Ids []int `json:"ids,omitempty"`
+}
+
+// applyEntry applies e to the resident data and its indexes and returns
+// the entry that reverts it. Callers must hold db.mux for writing.
+func (db *DB) applyEntry(e journalEntry) (journalEntry, error) {
+	switch e.Op {
+	case opPutChirp, opDeleteChirp:
+		id := e.Id
+		if e.Chirp != nil {
+			id = e.Chirp.Id
+		}
+		if old := replace(db.data.Chirps, id, e.Chirp, db.idx.addChirp, db.idx.removeChirp); old != nil {
+			return journalEntry{Op: opPutChirp, Chirp: old}, nil
+		}
+		return journalEntry{Op: opDeleteChirp, Id: id}, nil
+	case opPutUser, opDeleteUser:
+		id := e.Id
+		if e.User != nil {
+			id = e.User.Id
+		}
+		if old := replace(db.data.Users, id, e.User, db.idx.addUser, db.idx.removeUser); old != nil {
+			return journalEntry{Op: opPutUser, User: old}, nil
+		}
+		return journalEntry{Op: opDeleteUser, Id: id}, nil
+	case opPutSession, opDeleteSession:
+		id := e.Id
+		if e.Session != nil {
+			id = e.Session.Id
+		}
+		if old := replace(db.data.Sessions, id, e.Session, db.idx.addSession, db.idx.removeSession); old != nil {
+			return journalEntry{Op: opPutSession, Session: old}, nil
+		}
+		return journalEntry{Op: opDeleteSession, Id: id}, nil
+	case opPutRevisions, opDeleteRevisions:
+		var value *[]entities.ChirpRevision
+		if e.Op == opPutRevisions {
+			value = &e.Revisions
+		}
+		if old := replace(db.data.ChirpRevisions, e.Id, value, noIndex, noIndex); old != nil {
+			return journalEntry{Op: opPutRevisions, Id: e.Id, Revisions: *old}, nil
+		}
+		return journalEntry{Op: opDeleteRevisions, Id: e.Id}, nil
+	case opPutFollows, opDeleteFollows:
+		return applyRelation(db.data.Follows, db.idx.followers, e, opPutFollows, opDeleteFollows), nil
+	case opPutLikes, opDeleteLikes:
+		return applyRelation(db.data.Likes, db.idx.likesByUser, e, opPutLikes, opDeleteLikes), nil
+	case opPutBlocks, opDeleteBlocks:
+		return applyRelation(db.data.Blocks, db.idx.blockedBy, e, opPutBlocks, opDeleteBlocks), nil
+	case opPutMutes, opDeleteMutes:
+		return applyRelation(db.data.Mutes, nil, e, opPutMutes, opDeleteMutes), nil
+	case opPutRechirp, opDeleteRechirp:
+		id := e.Id
+		if e.Rechirp != nil {
+			id = e.Rechirp.Id
+		}
+		if old := replace(db.data.Rechirps, id, e.Rechirp, db.idx.addRechirp, db.idx.removeRechirp); old != nil {
+			return journalEntry{Op: opPutRechirp, Rechirp: old}, nil
+		}
+		return journalEntry{Op: opDeleteRechirp, Id: id}, nil
+	case opPutReport, opDeleteReport:
+		id := e.Id
+		if e.Report != nil {
+			id = e.Report.Id
+		}
+		if old := replace(db.data.Reports, id, e.Report, db.idx.addReport, db.idx.removeReport); old != nil {
+			return journalEntry{Op: opPutReport, Report: old}, nil
+		}
+		return journalEntry{Op: opDeleteReport, Id: id}, nil
+	case opPutEvent, opDeleteEvent:
+		id := e.Id
+		if e.Event != nil {
+			id = e.Event.Id
+		}
+		if old := replace(db.data.SecurityEvents, id, e.Event, db.idx.addEvent, db.idx.removeEvent); old != nil {
+			return journalEntry{Op: opPutEvent, Event: old}, nil
+		}
+		return journalEntry{Op: opDeleteEvent, Id: id}, nil
+	case opPutRevoked, opDeleteRevoked:
+		if e.Revoked == nil {
+			return journalEntry{}, fmt.Errorf("journal op %q without token", e.Op)
+		}
+		var value *entities.AccessToken
+		if e.Op == opPutRevoked {
+			value = e.Revoked
+		}
+		if old := replace(db.data.RevokedTokens, e.Revoked.Id, value, noIndex, noIndex); old != nil {
+			return journalEntry{Op: opPutRevoked, Revoked: old}, nil
+		}
+		return journalEntry{Op: opDeleteRevoked, Revoked: e.Revoked}, nil
+	}
+	return journalEntry{}, fmt.Errorf("unknown journal op %q", e.Op)
+}
+
+// applyRelation sets or deletes, depending on the op, the targets of e.Id
+// in the relation m, keeping its reverse index in sync when there is one
+func applyRelation(m map[int][]int, reverse map[int]map[int]struct{}, e journalEntry, putOp, deleteOp string) journalEntry {
+	undo := journalEntry{Op: deleteOp, Id: e.Id}
+	if old, ok := m[e.Id]; ok {
+		if reverse != nil {
+			for _, to := range old {
+				removeFromSet(reverse, to, e.Id)
+			}
+		}
+		delete(m, e.Id)
+		undo = journalEntry{Op: putOp, Id: e.Id, Ids: old}
+	}
+	if e.Op == putOp {
+		m[e.Id] = e.Ids
+		if reverse != nil {
+			for _, to := range e.Ids {
+				addToSet(reverse, to, e.Id)
+			}
+		}
+	}
+	return undo
+}
+
+// noIndex is passed to replace for collections without secondary indexes
+func noIndex[V any](V) {}
+
+// replace sets m[key] to value, or deletes it when value is nil, keeping
+// the indexes in sync through add and remove. It returns the previous value.
+func replace[K comparable, V any](m map[K]V, key K, value *V, add, remove func(V)) *V {
+	var old *V
+	if prev, ok := m[key]; ok {
+		remove(prev)
+		delete(m, key)
+		old = &prev
+	}
+	if value != nil {
+		m[key] = *value
+		add(*value)
+	}
+	return old
+}
+
+func (db *DB) journalPath() string {
+	return db.path + ".journal"
+}
+
+// appendJournal durably appends one commit worth of entries as a single line
+func (db *DB) appendJournal(entries []journalEntry) error {
+	dat, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(db.journalPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(dat, '\n')); err != nil {
+		// drop the partial line so later commits stay readable
+		f.Truncate(info.Size())
+		return err
+	}
+	return f.Sync()
+}
+
+// readJournal returns the commits recorded in the journal
+func (db *DB) readJournal() ([][]journalEntry, error) {
+	f, err := os.Open(db.journalPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return decodeJournal[journalEntry](f)
+}
+
+// decodeJournal decodes the commits of a journal, one per line.
+// A torn trailing line, left by a crash mid-append, is dropped,
+// an unreadable line followed by others is an error.
+func decodeJournal[E any](r io.Reader) ([][]E, error) {
+	commits := [][]E{}
+	var torn error
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if torn != nil {
+			return nil, fmt.Errorf("journal commit %d: %w", len(commits)+1, torn)
+		}
+		var entries []E
+		if err := json.Unmarshal(scanner.Bytes(), &entries); err != nil {
+			torn = err
+			continue
+		}
+		commits = append(commits, entries)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if torn != nil {
+		log.Printf("journal: dropping torn last commit: %v", torn)
+	}
+	return commits, nil
+}
+
+func (db *DB) truncateJournal() error {
+	err := os.Truncate(db.journalPath(), 0)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	db.journaled = 0
+	return nil
+}
+
+// recoverJournal replays the journal on top of the loaded snapshot,
+// then compacts the result back into the snapshot.
+// Callers must hold db.mux for writing.
+func (db *DB) recoverJournal() error {
+	commits, err := db.readJournal()
+	if err != nil {
+		return err
+	}
+	if len(commits) == 0 {
+		return db.truncateJournal()
+	}
+	for _, entries := range commits {
+		for _, e := range entries {
+			if _, err := db.applyEntry(e); err != nil {
+				return err
+			}
+		}
+	}
+	return db.compact()
+}
+
+// compact rewrites the snapshot with the resident data and truncates the journal
+func (db *DB) compact() error {
+	if err := db.writeSnapshot(*db.data); err != nil {
+		return err
+	}
+	return db.truncateJournal()
+}
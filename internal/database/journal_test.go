@@ -0,0 +1,121 @@
+package database
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+// newTestJSONDB opens a JSON database in a temporary directory
+// and creates a user in it
+func newTestJSONDB(t *testing.T) (*DB, string, int) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "database.json")
+	db, err := NewDB(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := db.CreateUser("a@example.com", "hash", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, path, user.Id
+}
+
+func createChirps(t *testing.T, db Store, userId, n int) {
+	t.Helper()
+	for i := range n {
+		if _, err := db.CreateChirp(entities.Chirp{UserId: userId, Body: fmt.Sprintf("chirp %d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func reopen(t *testing.T, db *DB, path string) *DB {
+	t.Helper()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewDB(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { reopened.Close() })
+	return reopened
+}
+
+func countChirps(t *testing.T, db Store) int {
+	t.Helper()
+	chirps, err := db.GetChirps(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(chirps)
+}
+
+func TestReopenDropsTornLastCommit(t *testing.T) {
+	db, path, userId := newTestJSONDB(t)
+	createChirps(t, db, userId, 2)
+	journal, err := os.ReadFile(path + ".journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a crash halfway through appending the second chirp
+	lines := bytes.SplitAfter(journal, []byte("\n"))
+	last := lines[len(lines)-2]
+	torn := append(bytes.Join(lines[:len(lines)-2], nil), last[:len(last)/2]...)
+	if err := os.WriteFile(path+".journal", torn, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	db = reopen(t, db, path)
+	if got := countChirps(t, db); got != 1 {
+		t.Fatalf("got %d chirps, want the one committed before the torn commit", got)
+	}
+	if journal, _ := os.ReadFile(path + ".journal"); len(journal) != 0 {
+		t.Fatal("the journal was not compacted into the snapshot")
+	}
+	createChirps(t, db, userId, 1)
+	if got := countChirps(t, reopen(t, db, path)); got != 2 {
+		t.Fatalf("got %d chirps after writing on the recovered database, want 2", got)
+	}
+}
+
+func TestReopenRefusesCorruptJournal(t *testing.T) {
+	db, path, userId := newTestJSONDB(t)
+	createChirps(t, db, userId, 2)
+	journal, err := os.ReadFile(path + ".journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an unreadable commit followed by readable ones is not a torn append
+	lines := bytes.SplitAfter(journal, []byte("\n"))
+	lines[1] = []byte("{\n")
+	if err := os.WriteFile(path+".journal", bytes.Join(lines, nil), 0640); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if _, err := NewDB(path, false); err == nil {
+		t.Fatal("opened a database with a corrupt journal")
+	}
+}
+
+func TestReopenAfterCompaction(t *testing.T) {
+	db, path, userId := newTestJSONDB(t)
+	// the user, a compaction, then a few commits left in the journal
+	createChirps(t, db, userId, compactEvery+4)
+	journal, err := os.ReadFile(path + ".journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := bytes.Count(journal, []byte("\n")); got != 5 {
+		t.Fatalf("got %d commits in the journal, want the 5 since the compaction", got)
+	}
+	if got := countChirps(t, reopen(t, db, path)); got != compactEvery+4 {
+		t.Fatalf("got %d chirps, want %d", got, compactEvery+4)
+	}
+}
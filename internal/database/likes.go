@@ -0,0 +1,94 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+var ErrPrivateChirp = errors.New("only public chirps can be rechirped")
+
+// Like is an idempotent operation that makes userId like chirpId
+func (db *DB) Like(userId, chirpId int) error {
+	return db.Update(func(tx *Tx) error {
+		chirp, found := tx.Chirp(chirpId)
+		if !found || !tx.Readable(chirp, &userId) {
+			return ErrChirpNotFound
+		}
+		if tx.Blocks(chirp.UserId, userId) {
+			return ErrBlocked
+		}
+		likes := tx.Likes(chirpId)
+		if slices.Contains(likes, userId) {
+			return nil
+		}
+		return tx.PutLikes(chirpId, append(likes, userId))
+	})
+}
+
+// Unlike is an idempotent operation that withdraws the like of userId on chirpId
+func (db *DB) Unlike(userId, chirpId int) error {
+	return db.Update(func(tx *Tx) error {
+		likes := tx.Likes(chirpId)
+		i := slices.Index(likes, userId)
+		if i == -1 {
+			return nil
+		}
+		return tx.PutLikes(chirpId, slices.Delete(likes, i, i+1))
+	})
+}
+
+// Rechirp is an idempotent operation that makes userId share chirpId with their followers.
+// Only public chirps can be rechirped.
+func (db *DB) Rechirp(userId, chirpId int) error {
+	return db.Update(func(tx *Tx) error {
+		chirp, found := tx.Chirp(chirpId)
+		if !found || !tx.Readable(chirp, &userId) {
+			return ErrChirpNotFound
+		}
+		if tx.Blocks(chirp.UserId, userId) {
+			return ErrBlocked
+		}
+		if chirp.Visibility != entities.VisibilityPublic {
+			return ErrPrivateChirp
+		}
+		if _, exists := tx.Rechirp(userId, chirpId); exists {
+			return nil
+		}
+		return tx.PutRechirp(entities.Rechirp{
+			Id:        tx.NextRechirpId(),
+			ChirpId:   chirpId,
+			UserId:    userId,
+			CreatedAt: time.Now().UTC(),
+		})
+	})
+}
+
+// Unrechirp is an idempotent operation that withdraws the rechirp of chirpId by userId
+func (db *DB) Unrechirp(userId, chirpId int) error {
+	return db.Update(func(tx *Tx) error {
+		rechirp, exists := tx.Rechirp(userId, chirpId)
+		if !exists {
+			return nil
+		}
+		return tx.DeleteRechirp(rechirp.Id)
+	})
+}
+
+// GetChirpStats counts the likes and rechirps of the given chirps,
+// reporting whether viewerId liked them when not nil
+func (db *DB) GetChirpStats(chirpIds []int, viewerId *int) (map[int]entities.ChirpStats, error) {
+	stats := map[int]entities.ChirpStats{}
+	err := db.View(func(tx *Tx) error {
+		for _, id := range chirpIds {
+			stats[id] = tx.ChirpStats(id, viewerId)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
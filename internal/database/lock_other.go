@@ -0,0 +1,11 @@
+//go:build !unix
+
+package database
+
+import "os"
+
+// lockFile only creates the file at path, there is no
+// advisory locking on this platform
+func lockFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0640)
+}
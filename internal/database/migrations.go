@@ -0,0 +1,467 @@
+package database
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+// migration upgrades the raw database document to version.
+// Migrations work on raw JSON so they do not depend on the
+// current shape of DBStructure.
+type migration struct {
+	version     int
+	description string
+	up          func(doc map[string]json.RawMessage) error
+}
+
+// migrations are applied in order to documents older than their version.
+// Never edit a released migration, append a new one instead.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "add schema version",
+		up: func(doc map[string]json.RawMessage) error {
+			for _, key := range []string{"chirps", "users", "tokens"} {
+				if v, ok := doc[key]; !ok || string(v) == "null" {
+					doc[key] = json.RawMessage("{}")
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     2,
+		description: "add chirp timestamps and revisions",
+		up: func(doc map[string]json.RawMessage) error {
+			now, err := json.Marshal(time.Now().UTC())
+			if err != nil {
+				return err
+			}
+			err = updateRecords(doc, "chirps", func(rec map[string]json.RawMessage) error {
+				rec["created_at"] = now
+				rec["updated_at"] = now
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			doc["chirp_revisions"] = json.RawMessage("{}")
+			return nil
+		},
+	},
+	{
+		// chirps may now carry in_reply_to and root_id,
+		// the version bump keeps older builds from dropping them
+		version:     3,
+		description: "add chirp replies",
+		up:          func(doc map[string]json.RawMessage) error { return nil },
+	},
+	{
+		version:     4,
+		description: "add follows",
+		up: func(doc map[string]json.RawMessage) error {
+			doc["follows"] = json.RawMessage("{}")
+			return nil
+		},
+	},
+	{
+		version:     5,
+		description: "add user handles and chirp hashtags and mentions",
+		up:          addHandlesAndHashtags,
+	},
+	{
+		version:     6,
+		description: "add likes and rechirps",
+		up: func(doc map[string]json.RawMessage) error {
+			doc["likes"] = json.RawMessage("{}")
+			doc["rechirps"] = json.RawMessage("{}")
+			return nil
+		},
+	},
+	{
+		// chirps may now carry moderation flags,
+		// the version bump keeps older builds from dropping them
+		version:     7,
+		description: "add chirp moderation flags",
+		up:          func(doc map[string]json.RawMessage) error { return nil },
+	},
+	{
+		version:     8,
+		description: "add reports",
+		up: func(doc map[string]json.RawMessage) error {
+			doc["reports"] = json.RawMessage("{}")
+			return nil
+		},
+	},
+	{
+		version:     9,
+		description: "add blocks and mutes",
+		up: func(doc map[string]json.RawMessage) error {
+			doc["blocks"] = json.RawMessage("{}")
+			doc["mutes"] = json.RawMessage("{}")
+			return nil
+		},
+	},
+	{
+		version:     10,
+		description: "add chirp visibility",
+		up: func(doc map[string]json.RawMessage) error {
+			return updateRecords(doc, "chirps", func(rec map[string]json.RawMessage) error {
+				rec["visibility"] = json.RawMessage(`"public"`)
+				return nil
+			})
+		},
+	},
+	{
+		version:     11,
+		description: "replace refresh tokens with sessions",
+		up:          tokensToSessions,
+	},
+	{
+		// sessions may now carry retired token hashes,
+		// the version bump keeps older builds from dropping them
+		version:     12,
+		description: "add refresh token rotation and security events",
+		up: func(doc map[string]json.RawMessage) error {
+			doc["security_events"] = json.RawMessage("{}")
+			return nil
+		},
+	},
+	{
+		// token hashes are now keyed, the unkeyed ones cannot be
+		// converted without the tokens so their sessions are dropped.
+		// Documents older than 11 lose the sessions it converted from
+		// refresh tokens here too, their users log in again like everyone
+		// else. Migration 11 is left as released.
+		version:     13,
+		description: "drop sessions with unkeyed token hashes",
+		up: func(doc map[string]json.RawMessage) error {
+			doc["sessions"] = json.RawMessage("{}")
+			return nil
+		},
+	},
+	{
+		// sessions may now carry their access tokens,
+		// the version bump keeps older builds from dropping them
+		version:     14,
+		description: "add access token revocation",
+		up: func(doc map[string]json.RawMessage) error {
+			doc["revoked_tokens"] = json.RawMessage("{}")
+			return nil
+		},
+	},
+}
+
+// addHandlesAndHashtags derives a handle for every user, in id order so
+// that the oldest account keeps the plain one, then parses the hashtags
+// and mentions of every chirp
+func addHandlesAndHashtags(doc map[string]json.RawMessage) error {
+	users := map[string]map[string]json.RawMessage{}
+	if err := json.Unmarshal(doc["users"], &users); err != nil {
+		return err
+	}
+	ids := make([]int, 0, len(users))
+	for key := range users {
+		id, err := strconv.Atoi(key)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	handles := map[string]int{}
+	for _, id := range ids {
+		rec := users[strconv.Itoa(id)]
+		var email string
+		if err := json.Unmarshal(rec["email"], &email); err != nil {
+			return err
+		}
+		handle := uniqueHandle(entities.HandleFromEmail(email), func(h string) bool {
+			_, taken := handles[h]
+			return taken
+		})
+		handles[handle] = id
+		rec["handle"], _ = json.Marshal(handle)
+	}
+	updated, err := json.Marshal(users)
+	if err != nil {
+		return err
+	}
+	doc["users"] = updated
+
+	return updateRecords(doc, "chirps", func(rec map[string]json.RawMessage) error {
+		var body string
+		if err := json.Unmarshal(rec["body"], &body); err != nil {
+			return err
+		}
+		rec["hashtags"], _ = json.Marshal(entities.ParseHashtags(body))
+		rec["mentions"], _ = json.Marshal(resolveMentions(body, func(h string) (int, bool) {
+			id, found := handles[h]
+			return id, found
+		}))
+		return nil
+	})
+}
+
+// unkeyedTokenHash is the hash sessions kept of their refresh token
+// until migration 13
+func unkeyedTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// tokensToSessions turns the refresh token each user had into a session
+// keeping the hash of the token. Sessions are numbered in user id order,
+// the device and the time of the login are unknown. Migration 13 drops
+// them again, since their hashes are not keyed.
+func tokensToSessions(doc map[string]json.RawMessage) error {
+	tokens := map[string]struct {
+		UserId    int             `json:"userId"`
+		Token     string          `json:"token"`
+		ExpiresAt json.RawMessage `json:"expiresAt"`
+	}{}
+	if raw, ok := doc["tokens"]; ok {
+		if err := json.Unmarshal(raw, &tokens); err != nil {
+			return err
+		}
+	}
+	userIds := make([]int, 0, len(tokens))
+	for _, t := range tokens {
+		userIds = append(userIds, t.UserId)
+	}
+	slices.Sort(userIds)
+	now, err := json.Marshal(time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	sessions := map[string]map[string]json.RawMessage{}
+	for i, userId := range userIds {
+		t := tokens[strconv.Itoa(userId)]
+		id := strconv.Itoa(i + 1)
+		sessions[id] = map[string]json.RawMessage{
+			"id":           json.RawMessage(id),
+			"user_id":      json.RawMessage(strconv.Itoa(userId)),
+			"token_hash":   json.RawMessage(`"` + unkeyedTokenHash(t.Token) + `"`),
+			"user_agent":   json.RawMessage(`""`),
+			"ip":           json.RawMessage(`""`),
+			"created_at":   now,
+			"last_used_at": now,
+			"expires_at":   t.ExpiresAt,
+		}
+	}
+	updated, err := json.Marshal(sessions)
+	if err != nil {
+		return err
+	}
+	doc["sessions"] = updated
+	delete(doc, "tokens")
+	return nil
+}
+
+// updateRecords calls fn on every record of the collection stored under key
+func updateRecords(doc map[string]json.RawMessage, key string, fn func(rec map[string]json.RawMessage) error) error {
+	coll := map[string]map[string]json.RawMessage{}
+	if err := json.Unmarshal(doc[key], &coll); err != nil {
+		return err
+	}
+	for _, rec := range coll {
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	updated, err := json.Marshal(coll)
+	if err != nil {
+		return err
+	}
+	doc[key] = updated
+	return nil
+}
+
+// SchemaVersion is the version of the documents written by this build
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// MigrationResult describes what Migrate did, or would do in dry-run mode
+type MigrationResult struct {
+	From    int
+	To      int
+	Applied []string
+	Backup  string
+}
+
+// Migrate upgrades the database file at path, along with any commit still
+// pending in its journal, to the current schema version. The pre-migration
+// files are copied to a backup first. With dryRun the migrations run in
+// memory only and nothing is written.
+func Migrate(path string, dryRun bool) (*MigrationResult, error) {
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc := map[string]json.RawMessage{}
+	if err := json.Unmarshal(dat, &doc); err != nil {
+		return nil, err
+	}
+	result := &MigrationResult{To: SchemaVersion()}
+	if result.From, err = documentVersion(doc); err != nil {
+		return nil, err
+	}
+	if result.From == result.To {
+		return result, nil
+	}
+
+	journalPath := path + ".journal"
+	journal, err := os.ReadFile(journalPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err := replayRawJournal(doc, journal); err != nil {
+		return nil, err
+	}
+	if result.Applied, err = upgradeDocument(doc, result.From); err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return result, nil
+	}
+
+	result.Backup = fmt.Sprintf("%s.v%d.bak", path, result.From)
+	if err := os.WriteFile(result.Backup, dat, 0640); err != nil {
+		return nil, err
+	}
+	if len(journal) > 0 {
+		if err := os.WriteFile(result.Backup+".journal", journal, 0640); err != nil {
+			return nil, err
+		}
+	}
+	migrated, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	db := &DB{path: path}
+	if err := db.writeRawSnapshot(migrated); err != nil {
+		return nil, err
+	}
+	if err := db.truncateJournal(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// documentVersion returns the schema version of a raw document,
+// failing when it was written by a newer build
+func documentVersion(doc map[string]json.RawMessage) (int, error) {
+	version := 0
+	if v, ok := doc["version"]; ok {
+		if err := json.Unmarshal(v, &version); err != nil {
+			return 0, fmt.Errorf("invalid schema version: %w", err)
+		}
+	}
+	if version > SchemaVersion() {
+		return 0, fmt.Errorf("database schema version %d is newer than supported version %d", version, SchemaVersion())
+	}
+	return version, nil
+}
+
+// upgradeDocument runs every migration newer than from on doc
+// and returns their descriptions
+func upgradeDocument(doc map[string]json.RawMessage, from int) ([]string, error) {
+	applied := []string{}
+	for _, m := range migrations {
+		if m.version <= from {
+			continue
+		}
+		if err := m.up(doc); err != nil {
+			return nil, fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+		}
+		applied = append(applied, fmt.Sprintf("%d: %s", m.version, m.description))
+	}
+	doc["version"] = json.RawMessage(fmt.Sprint(SchemaVersion()))
+	return applied, nil
+}
+
+// rawJournalKinds maps the entity kind of a journal op to the document
+// collection it lives in, the entry field carrying it and its key field,
+// if empty the key is the entry id
+var rawJournalKinds = map[string]struct{ collection, payload, key string }{
+	"chirp":     {"chirps", "chirp", "id"},
+	"user":      {"users", "user", "id"},
+	"token":     {"tokens", "token", "userId"},
+	"session":   {"sessions", "session", "id"},
+	"event":     {"security_events", "event", "id"},
+	"revoked":   {"revoked_tokens", "revoked", "id"},
+	"revisions": {"chirp_revisions", "revisions", ""},
+	"follows":   {"follows", "ids", ""},
+	"likes":     {"likes", "ids", ""},
+	"rechirp":   {"rechirps", "rechirp", "id"},
+	"report":    {"reports", "report", "id"},
+	"blocks":    {"blocks", "ids", ""},
+	"mutes":     {"mutes", "ids", ""},
+}
+
+// replayRawJournal folds journal commits into a document written by an
+// older schema version, so that migrations see every committed change
+func replayRawJournal(doc map[string]json.RawMessage, journal []byte) error {
+	commits, err := decodeJournal[map[string]json.RawMessage](bytes.NewReader(journal))
+	if err != nil {
+		return err
+	}
+	for _, entries := range commits {
+		for _, e := range entries {
+			var op string
+			if err := json.Unmarshal(e["op"], &op); err != nil {
+				return err
+			}
+			kind, action, _ := bytes.Cut([]byte(op), []byte("."))
+			k, ok := rawJournalKinds[string(kind)]
+			if !ok {
+				return fmt.Errorf("unknown journal op %q", op)
+			}
+			coll := map[string]json.RawMessage{}
+			if raw, ok := doc[k.collection]; ok {
+				if err := json.Unmarshal(raw, &coll); err != nil {
+					return err
+				}
+			}
+			// deletes carry only the id, except for the kinds keyed
+			// by a string, which carry the payload in both ops
+			key := e["id"]
+			if _, ok := e[k.payload]; ok && k.key != "" {
+				payload := map[string]json.RawMessage{}
+				if err := json.Unmarshal(e[k.payload], &payload); err != nil {
+					return err
+				}
+				key = payload[k.key]
+			}
+			var keyStr string
+			if err := json.Unmarshal(key, &keyStr); err != nil {
+				keyStr = string(key)
+			}
+			switch string(action) {
+			case "put":
+				coll[keyStr] = e[k.payload]
+			case "delete":
+				delete(coll, keyStr)
+			default:
+				return fmt.Errorf("unknown journal op %q", op)
+			}
+			updated, err := json.Marshal(coll)
+			if err != nil {
+				return err
+			}
+			doc[k.collection] = updated
+		}
+	}
+	return nil
+}
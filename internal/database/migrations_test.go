@@ -0,0 +1,143 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+// copyFixture copies the database files of testdata/name to a temporary
+// directory and returns the path of the database there
+func copyFixture(t *testing.T, name string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, file := range []string{"database.json", "database.json.journal"} {
+		dat, err := os.ReadFile(filepath.Join("testdata", name, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, file), dat, 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(dir, "database.json")
+}
+
+func TestMigrateFromVersion0(t *testing.T) {
+	path := copyFixture(t, "v0")
+	snapshot, _ := os.ReadFile(path)
+	journal, _ := os.ReadFile(path + ".journal")
+
+	dryRun, err := Migrate(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.ReadFile(path); !bytes.Equal(after, snapshot) {
+		t.Fatal("the dry run rewrote the database")
+	}
+	result, err := Migrate(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.From != 0 || result.To != SchemaVersion() || len(result.Applied) != len(migrations) {
+		t.Fatalf("migrated from %d to %d with %d migrations", result.From, result.To, len(result.Applied))
+	}
+	if !slices.Equal(dryRun.Applied, result.Applied) {
+		t.Errorf("the dry run applied %v, the migration %v", dryRun.Applied, result.Applied)
+	}
+
+	if backup, _ := os.ReadFile(result.Backup); !bytes.Equal(backup, snapshot) {
+		t.Error("the backup is not the original database")
+	}
+	if backup, _ := os.ReadFile(result.Backup + ".journal"); !bytes.Equal(backup, journal) {
+		t.Error("the backup journal is not the original journal")
+	}
+	if after, _ := os.ReadFile(path + ".journal"); len(after) != 0 {
+		t.Error("the journal was not folded into the migrated database")
+	}
+
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := map[string]json.RawMessage{}
+	if err := json.Unmarshal(dat, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := documentVersion(doc); version != SchemaVersion() {
+		t.Errorf("got version %d", version)
+	}
+	if _, found := doc["tokens"]; found {
+		t.Error("the refresh tokens were kept")
+	}
+
+	db, err := NewDB(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for id, handle := range map[int]string{1: "ada", 2: "bob"} {
+		user, err := db.GetUserByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Handle != handle {
+			t.Errorf("user %d: got handle %q, want %q", id, user.Handle, handle)
+		}
+		// the sessions converted from refresh tokens had unkeyed hashes
+		if sessions, _ := db.GetSessions(id); len(sessions) != 0 {
+			t.Errorf("user %d: got %d sessions, want none", id, len(sessions))
+		}
+	}
+	chirps, err := db.GetChirps(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chirps) != 2 {
+		t.Fatalf("got %d chirps, want the one of the snapshot and the one left by the journal", len(chirps))
+	}
+	for _, c := range chirps {
+		if c.CreatedAt.IsZero() || c.Visibility != entities.VisibilityPublic {
+			t.Errorf("chirp %d: created at %v, visibility %q", c.Id, c.CreatedAt, c.Visibility)
+		}
+	}
+	slices.SortFunc(chirps, func(a, b entities.Chirp) int { return a.Id - b.Id })
+	if got := chirps[0].Hashtags; !slices.Equal(got, []string{"go"}) {
+		t.Errorf("got hashtags %v", got)
+	}
+	if got := chirps[1].Mentions; !slices.Equal(got, []entities.Mention{{Handle: "ada", UserId: 1}}) {
+		t.Errorf("got mentions %v", got)
+	}
+}
+
+func TestTokensToSessions(t *testing.T) {
+	doc := map[string]json.RawMessage{
+		"tokens": json.RawMessage(`{"2":{"userId":2,"token":"b","expiresAt":"2030-01-01T00:00:00Z"},"1":{"userId":1,"token":"a","expiresAt":"2030-01-01T00:00:00Z"}}`),
+	}
+	if err := tokensToSessions(doc); err != nil {
+		t.Fatal(err)
+	}
+	sessions := map[string]entities.Session{}
+	if err := json.Unmarshal(doc["sessions"], &sessions); err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("got %d sessions, want 2", len(sessions))
+	}
+	// numbered in user id order
+	want := map[string]struct {
+		userId int
+		token  string
+	}{"1": {1, "a"}, "2": {2, "b"}}
+	for id, w := range want {
+		s := sessions[id]
+		if s.UserId != w.userId || s.TokenHash != unkeyedTokenHash(w.token) {
+			t.Errorf("session %s: got user %d and hash %s", id, s.UserId, s.TokenHash)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+var ErrReportNotFound = errors.New("report not found")
+var ErrDuplicateReport = errors.New("report already filed")
+var ErrReportResolved = errors.New("report already resolved")
+var ErrNoReportedChirp = errors.New("report is not about a chirp")
+var ErrInvalidResolution = errors.New("invalid report resolution")
+
+// flagReason is the reason of the reports filed for chirps flagged by the moderation rules
+func flagReason(flags []string) string {
+	return fmt.Sprintf("flagged by moderation rules: %s", strings.Join(flags, ", "))
+}
+
+// fileFlagReport queues chirp for review when the moderation rules
+// flagged it, unless it is already waiting in the queue
+func fileFlagReport(tx *Tx, chirp entities.Chirp) error {
+	if len(chirp.Flags) == 0 {
+		return nil
+	}
+	for _, r := range tx.ChirpReports(chirp.Id) {
+		if r.ReporterId == nil && r.Status == entities.ReportOpen {
+			return nil
+		}
+	}
+	return tx.PutReport(entities.Report{
+		Id:        tx.NextReportId(),
+		ChirpId:   &chirp.Id,
+		UserId:    chirp.UserId,
+		Reason:    flagReason(chirp.Flags),
+		Status:    entities.ReportOpen,
+		CreatedAt: time.Now().UTC(),
+	})
+}
+
+// isDuplicateReport tells whether report is already filed as the open report r
+func isDuplicateReport(r, report entities.Report) bool {
+	sameChirp := (r.ChirpId == nil && report.ChirpId == nil) ||
+		(r.ChirpId != nil && report.ChirpId != nil && *r.ChirpId == *report.ChirpId)
+	return r.Status == entities.ReportOpen &&
+		r.ReporterId != nil && report.ReporterId != nil && *r.ReporterId == *report.ReporterId &&
+		r.UserId == report.UserId && sameChirp
+}
+
+// CreateReport files an open report about a chirp the reporter may read, when ChirpId
+// is set, or else about the user UserId. For chirps, UserId is set to
+// their author.
+func (db *DB) CreateReport(report entities.Report) (*entities.Report, error) {
+	err := db.Update(func(tx *Tx) error {
+		if report.ChirpId != nil {
+			chirp, found := tx.Chirp(*report.ChirpId)
+			if !found || !tx.Readable(chirp, report.ReporterId) {
+				return ErrChirpNotFound
+			}
+			report.UserId = chirp.UserId
+		} else if _, found := tx.User(report.UserId); !found {
+			return ErrUserNotFound
+		}
+		for _, r := range tx.UserReports(report.UserId) {
+			if isDuplicateReport(r, report) {
+				return ErrDuplicateReport
+			}
+		}
+		report.Id = tx.NextReportId()
+		report.Status = entities.ReportOpen
+		report.CreatedAt = time.Now().UTC()
+		report.ResolvedAt = nil
+		return tx.PutReport(report)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// GetReports returns the reports with the given status, or all of them
+// when status is empty, oldest first
+func (db *DB) GetReports(status entities.ReportStatus) ([]entities.Report, error) {
+	reports := []entities.Report{}
+	err := db.View(func(tx *Tx) error {
+		for _, r := range tx.Reports() {
+			if status == "" || r.Status == status {
+				reports = append(reports, r)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
+// ResolveReport closes an open report with the given status, taking the
+// matching action: hiding the reported chirp or suspending the reported
+// user closes their other open reports along with it
+func (db *DB) ResolveReport(id int, status entities.ReportStatus) (*entities.Report, error) {
+	var report entities.Report
+	err := db.Update(func(tx *Tx) error {
+		var found bool
+		if report, found = tx.Report(id); !found {
+			return ErrReportNotFound
+		}
+		if report.Status != entities.ReportOpen {
+			return ErrReportResolved
+		}
+		resolved := []entities.Report{report}
+		switch status {
+		case entities.ReportDismissed:
+		case entities.ReportChirpHidden:
+			if report.ChirpId == nil {
+				return ErrNoReportedChirp
+			}
+			chirp, found := tx.Chirp(*report.ChirpId)
+			if !found {
+				return ErrChirpNotFound
+			}
+			chirp.Hidden = true
+			if err := tx.PutChirp(chirp); err != nil {
+				return err
+			}
+			resolved = tx.ChirpReports(chirp.Id)
+		case entities.ReportUserSuspended:
+			user, found := tx.User(report.UserId)
+			if !found {
+				return ErrUserNotFound
+			}
+			user.Suspended = true
+			if err := tx.PutUser(user); err != nil {
+				return err
+			}
+			if err := deleteUserSessions(tx, user.Id); err != nil {
+				return err
+			}
+			resolved = tx.UserReports(user.Id)
+		default:
+			return ErrInvalidResolution
+		}
+		now := time.Now().UTC()
+		for _, r := range resolved {
+			if r.Status != entities.ReportOpen {
+				continue
+			}
+			r.Status = status
+			r.ResolvedAt = &now
+			if r.Id == id {
+				report = r
+			}
+			if err := tx.PutReport(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
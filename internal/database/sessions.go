@@ -0,0 +1,200 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+var ErrSessionExpired = errors.New("session expired")
+var ErrTokenReused = errors.New("refresh token reused, session revoked")
+
+// maxRetiredTokens is how many of its last refresh tokens a session keeps
+// the hashes of. Presenting an older one again is refused as unknown,
+// without revoking the session.
+const maxRetiredTokens = 100
+
+// CreateSession saves a new session, assigning its id and timestamps.
+// The expired sessions of the same user are dropped.
+func (db *DB) CreateSession(session entities.Session) (*entities.Session, error) {
+	err := db.Update(func(tx *Tx) error {
+		now := time.Now().UTC()
+		for _, s := range tx.UserSessions(session.UserId) {
+			if s.ExpiresAt.Before(now) {
+				if err := tx.DeleteSession(s.Id); err != nil {
+					return err
+				}
+			}
+		}
+		session.Id = tx.NextSessionId()
+		session.ExpiresAt = session.ExpiresAt.UTC()
+		session.CreatedAt = now
+		session.LastUsedAt = now
+		return tx.PutSession(session)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// RotateSession replaces the refresh token with hash tokenHash by the one
+// with hash newTokenHash, retiring the former, and adds accessToken to the
+// session, unless it expired. A retired token presented again revokes the
+// whole session, which is recorded as a security event along with the
+// client ip and user agent, and fails with ErrTokenReused.
+func (db *DB) RotateSession(tokenHash, newTokenHash string, accessToken entities.AccessToken, ip, userAgent string) (*entities.Session, error) {
+	var session entities.Session
+	reused := false
+	err := db.Update(func(tx *Tx) error {
+		now := time.Now().UTC()
+		var found bool
+		if session, found = tx.SessionByToken(tokenHash); !found {
+			if session, reused = tx.SessionByRetiredToken(tokenHash); !reused {
+				return ErrSessionNotFound
+			}
+			if err := endSession(tx, session, now); err != nil {
+				return err
+			}
+			return tx.PutEvent(entities.SecurityEvent{
+				Id:        tx.NextEventId(),
+				UserId:    session.UserId,
+				Kind:      entities.SecurityEventTokenReuse,
+				SessionId: session.Id,
+				IP:        ip,
+				UserAgent: userAgent,
+				CreatedAt: now,
+			})
+		}
+		if session.ExpiresAt.Before(now) {
+			return ErrSessionExpired
+		}
+		session.RetiredHashes = append(session.RetiredHashes, session.TokenHash)
+		if extra := len(session.RetiredHashes) - maxRetiredTokens; extra > 0 {
+			session.RetiredHashes = slices.Clone(session.RetiredHashes[extra:])
+		}
+		session.TokenHash = newTokenHash
+		session.LastUsedAt = now
+		session.AccessTokens = append(unexpiredTokens(session.AccessTokens, now), accessToken)
+		return tx.PutSession(session)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if reused {
+		return nil, ErrTokenReused
+	}
+	return &session, nil
+}
+
+// GetSessions returns the sessions of userId that did not expire, oldest first
+func (db *DB) GetSessions(userId int) ([]entities.Session, error) {
+	var sessions []entities.Session
+	err := db.View(func(tx *Tx) error {
+		now := time.Now().UTC()
+		sessions = []entities.Session{}
+		for _, s := range tx.UserSessions(userId) {
+			if !s.ExpiresAt.Before(now) {
+				sessions = append(sessions, s)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// DeleteSession ends the session id of userId
+func (db *DB) DeleteSession(userId, id int) error {
+	return db.Update(func(tx *Tx) error {
+		session, found := tx.Session(id)
+		if !found || session.UserId != userId {
+			return ErrSessionNotFound
+		}
+		return endSession(tx, session, time.Now().UTC())
+	})
+}
+
+// DeleteSessionByToken ends the session whose refresh token has the given hash
+func (db *DB) DeleteSessionByToken(tokenHash string) error {
+	return db.Update(func(tx *Tx) error {
+		session, found := tx.SessionByToken(tokenHash)
+		if !found {
+			return ErrSessionNotFound
+		}
+		return endSession(tx, session, time.Now().UTC())
+	})
+}
+
+// DeleteUserSessions is an idempotent operation that ends every session of userId
+func (db *DB) DeleteUserSessions(userId int) error {
+	return db.Update(func(tx *Tx) error {
+		return deleteUserSessions(tx, userId)
+	})
+}
+
+func deleteUserSessions(tx *Tx, userId int) error {
+	now := time.Now().UTC()
+	for _, s := range tx.UserSessions(userId) {
+		if err := endSession(tx, s, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// endSession deletes session and denylists its access tokens that did
+// not expire yet. The denylisted tokens that expired are dropped.
+func endSession(tx *Tx, session entities.Session, now time.Time) error {
+	for _, t := range tx.RevokedTokens() {
+		if t.ExpiresAt.Before(now) {
+			if err := tx.DeleteRevokedToken(t); err != nil {
+				return err
+			}
+		}
+	}
+	for _, t := range unexpiredTokens(session.AccessTokens, now) {
+		if err := tx.PutRevokedToken(t); err != nil {
+			return err
+		}
+	}
+	return tx.DeleteSession(session.Id)
+}
+
+func unexpiredTokens(tokens []entities.AccessToken, now time.Time) []entities.AccessToken {
+	unexpired := []entities.AccessToken{}
+	for _, t := range tokens {
+		if !t.ExpiresAt.Before(now) {
+			unexpired = append(unexpired, t)
+		}
+	}
+	return unexpired
+}
+
+// IsTokenRevoked tells whether the access token with the given jti was revoked
+func (db *DB) IsTokenRevoked(id string) (bool, error) {
+	var revoked bool
+	err := db.View(func(tx *Tx) error {
+		revoked = tx.IsRevoked(id)
+		return nil
+	})
+	return revoked, err
+}
+
+// GetSecurityEvents returns the security events of userId, newest first
+func (db *DB) GetSecurityEvents(userId int) ([]entities.SecurityEvent, error) {
+	var events []entities.SecurityEvent
+	err := db.View(func(tx *Tx) error {
+		events = tx.UserEvents(userId)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
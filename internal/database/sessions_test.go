@@ -0,0 +1,233 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+// forEachStore runs test against a fresh store of every kind
+func forEachStore(t *testing.T, test func(t *testing.T, db Store)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryDB())
+	})
+	t.Run("json", func(t *testing.T) {
+		db, err := NewDB(filepath.Join(t.TempDir(), "database.json"), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		test(t, db)
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		test(t, newTestSQLiteDB(t))
+	})
+}
+
+func testAccessToken(id string) entities.AccessToken {
+	return entities.AccessToken{Id: id, ExpiresAt: time.Now().Add(time.Hour).UTC()}
+}
+
+// newTestSession creates a user and a session with refresh token hash "hash-0"
+// and access token "access-0"
+func newTestSession(t *testing.T, db Store) *entities.Session {
+	t.Helper()
+	user, err := db.CreateUser("a@example.com", "hash", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := db.CreateSession(entities.Session{
+		UserId:       user.Id,
+		TokenHash:    "hash-0",
+		ExpiresAt:    time.Now().Add(time.Hour),
+		AccessTokens: []entities.AccessToken{testAccessToken("access-0")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+func TestRotateSessionRevokesOnReuse(t *testing.T) {
+	forEachStore(t, func(t *testing.T, db Store) {
+		session := newTestSession(t, db)
+		if _, err := db.RotateSession("hash-0", "hash-1", testAccessToken("access-1"), "1.2.3.4", "test"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.RotateSession("hash-1", "hash-2", testAccessToken("access-2"), "1.2.3.4", "test"); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := db.RotateSession("hash-0", "hash-3", testAccessToken("access-3"), "5.6.7.8", "thief")
+		if !errors.Is(err, ErrTokenReused) {
+			t.Fatalf("reusing a retired token: got %v, want ErrTokenReused", err)
+		}
+		if _, err := db.RotateSession("hash-2", "hash-4", testAccessToken("access-4"), "1.2.3.4", "test"); !errors.Is(err, ErrSessionNotFound) {
+			t.Fatalf("current token after reuse: got %v, want ErrSessionNotFound", err)
+		}
+		events, err := db.GetSecurityEvents(session.UserId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 1 || events[0].Kind != entities.SecurityEventTokenReuse || events[0].IP != "5.6.7.8" {
+			t.Fatalf("got events %+v, want the reuse from 5.6.7.8", events)
+		}
+	})
+}
+
+func TestRotateSessionCapsRetiredTokens(t *testing.T) {
+	forEachStore(t, func(t *testing.T, db Store) {
+		newTestSession(t, db)
+		rotations := maxRetiredTokens + 5
+		for i := range rotations {
+			_, err := db.RotateSession(fmt.Sprintf("hash-%d", i), fmt.Sprintf("hash-%d", i+1), testAccessToken(fmt.Sprintf("access-%d", i+1)), "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if memDB, ok := db.(*DB); ok {
+			err := memDB.View(func(tx *Tx) error {
+				session, _ := tx.SessionByToken(fmt.Sprintf("hash-%d", rotations))
+				if len(session.RetiredHashes) != maxRetiredTokens {
+					t.Errorf("got %d retired hashes, want %d", len(session.RetiredHashes), maxRetiredTokens)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if sqliteDB, ok := db.(*SQLiteDB); ok {
+			var count int
+			if err := sqliteDB.conn.QueryRow("SELECT COUNT(*) FROM retired_tokens").Scan(&count); err != nil {
+				t.Fatal(err)
+			}
+			if count != maxRetiredTokens {
+				t.Errorf("got %d retired tokens, want %d", count, maxRetiredTokens)
+			}
+		}
+
+		// the oldest retired tokens are forgotten, the recent ones still detected
+		if _, err := db.RotateSession("hash-0", "hash-x", testAccessToken("access-x"), "", ""); !errors.Is(err, ErrSessionNotFound) {
+			t.Fatalf("forgotten retired token: got %v, want ErrSessionNotFound", err)
+		}
+		if _, err := db.RotateSession(fmt.Sprintf("hash-%d", rotations-1), "hash-y", testAccessToken("access-y"), "", ""); !errors.Is(err, ErrTokenReused) {
+			t.Fatalf("recent retired token: got %v, want ErrTokenReused", err)
+		}
+	})
+}
+
+func TestEndingSessionsRevokesAccessTokens(t *testing.T) {
+	ends := map[string]func(db Store, session *entities.Session) error{
+		"logout": func(db Store, session *entities.Session) error {
+			return db.DeleteSessionByToken(session.TokenHash)
+		},
+		"delete session": func(db Store, session *entities.Session) error {
+			return db.DeleteSession(session.UserId, session.Id)
+		},
+		"logout everywhere": func(db Store, session *entities.Session) error {
+			return db.DeleteUserSessions(session.UserId)
+		},
+		"suspension": func(db Store, session *entities.Session) error {
+			report, err := db.CreateReport(entities.Report{UserId: session.UserId, Reason: "spam"})
+			if err != nil {
+				return err
+			}
+			_, err = db.ResolveReport(report.Id, entities.ReportUserSuspended)
+			return err
+		},
+		"refresh token reuse": func(db Store, session *entities.Session) error {
+			if _, err := db.RotateSession("hash-0", "hash-1", testAccessToken("access-1"), "", ""); err != nil {
+				return err
+			}
+			if _, err := db.RotateSession("hash-0", "hash-2", testAccessToken("access-2"), "", ""); !errors.Is(err, ErrTokenReused) {
+				return fmt.Errorf("got %v, want ErrTokenReused", err)
+			}
+			return nil
+		},
+	}
+	for name, end := range ends {
+		t.Run(name, func(t *testing.T) {
+			forEachStore(t, func(t *testing.T, db Store) {
+				session := newTestSession(t, db)
+				if revoked, err := db.IsTokenRevoked("access-0"); err != nil || revoked {
+					t.Fatalf("before ending the session: got %v and %v", revoked, err)
+				}
+				if err := end(db, session); err != nil {
+					t.Fatal(err)
+				}
+				if revoked, err := db.IsTokenRevoked("access-0"); err != nil || !revoked {
+					t.Fatalf("after ending the session: got %v and %v, want revoked", revoked, err)
+				}
+				if revoked, _ := db.IsTokenRevoked("unknown"); revoked {
+					t.Fatal("a token of no session is revoked")
+				}
+			})
+		})
+	}
+}
+
+func TestEndingSessionsSkipsExpiredAccessTokens(t *testing.T) {
+	forEachStore(t, func(t *testing.T, db Store) {
+		user, err := db.CreateUser("a@example.com", "hash", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expired := entities.AccessToken{Id: "expired", ExpiresAt: time.Now().Add(-time.Minute).UTC()}
+		_, err = db.CreateSession(entities.Session{
+			UserId:       user.Id,
+			TokenHash:    "hash-0",
+			ExpiresAt:    time.Now().Add(time.Hour),
+			AccessTokens: []entities.AccessToken{expired},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.DeleteUserSessions(user.Id); err != nil {
+			t.Fatal(err)
+		}
+		if revoked, _ := db.IsTokenRevoked("expired"); revoked {
+			t.Fatal("an expired token was denylisted")
+		}
+	})
+}
+
+func TestEndingSessionsPrunesExpiredRevocations(t *testing.T) {
+	forEachStore(t, func(t *testing.T, db Store) {
+		user, err := db.CreateUser("a@example.com", "hash", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, expiresIn := range []time.Duration{200 * time.Millisecond, time.Hour} {
+			_, err := db.CreateSession(entities.Session{
+				UserId:       user.Id,
+				TokenHash:    fmt.Sprintf("hash-%d", i),
+				ExpiresAt:    time.Now().Add(time.Hour),
+				AccessTokens: []entities.AccessToken{{Id: fmt.Sprintf("access-%d", i), ExpiresAt: time.Now().Add(expiresIn).UTC()}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := db.DeleteSessionByToken("hash-0"); err != nil {
+			t.Fatal(err)
+		}
+		if revoked, _ := db.IsTokenRevoked("access-0"); !revoked {
+			t.Fatal("access-0 not denylisted")
+		}
+		time.Sleep(300 * time.Millisecond)
+		if err := db.DeleteSessionByToken("hash-1"); err != nil {
+			t.Fatal(err)
+		}
+		if revoked, _ := db.IsTokenRevoked("access-0"); revoked {
+			t.Error("the expired access-0 is still denylisted")
+		}
+		if revoked, _ := db.IsTokenRevoked("access-1"); !revoked {
+			t.Error("access-1 not denylisted")
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const snapshotFormat = "chirpy-snapshot"
+
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+// Backupper is implemented by stores that can take an online,
+// point-in-time snapshot of their content and restore one
+type Backupper interface {
+	Backup(w io.Writer) error
+	Restore(r io.Reader) error
+}
+
+var _ Backupper = (*DB)(nil)
+
+// snapshotEnvelope is the gzip-compressed document written by Backup
+type snapshotEnvelope struct {
+	Format        string          `json:"format"`
+	SchemaVersion int             `json:"schema_version"`
+	CreatedAt     time.Time       `json:"created_at"`
+	Checksum      string          `json:"checksum"`
+	Data          json.RawMessage `json:"data"`
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Backup writes a compressed snapshot of the database as of now.
+// Writers are blocked only while the data is serialized.
+func (db *DB) Backup(w io.Writer) error {
+	var data []byte
+	err := db.View(func(tx *Tx) error {
+		var err error
+		data, err = json.Marshal(db.data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	envelope := snapshotEnvelope{
+		Format:        snapshotFormat,
+		SchemaVersion: SchemaVersion(),
+		CreatedAt:     time.Now().UTC(),
+		Checksum:      checksum(data),
+		Data:          data,
+	}
+	gz := gzip.NewWriter(w)
+	if err := json.NewEncoder(gz).Encode(envelope); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// readSnapshot validates a snapshot written by Backup and returns its data
+// upgraded to the current schema version
+func readSnapshot(r io.Reader) (*DBStructure, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	defer gz.Close()
+	envelope := snapshotEnvelope{}
+	if err := json.NewDecoder(gz).Decode(&envelope); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	if envelope.Format != snapshotFormat {
+		return nil, fmt.Errorf("%w: unknown format %q", ErrInvalidSnapshot, envelope.Format)
+	}
+	if checksum(envelope.Data) != envelope.Checksum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidSnapshot)
+	}
+
+	doc := map[string]json.RawMessage{}
+	if err := json.Unmarshal(envelope.Data, &doc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	version, err := documentVersion(doc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	if version != envelope.SchemaVersion {
+		return nil, fmt.Errorf("%w: schema version mismatch", ErrInvalidSnapshot)
+	}
+	if _, err := upgradeDocument(doc, version); err != nil {
+		return nil, err
+	}
+	dat, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	dbObj := &DBStructure{}
+	if err := json.Unmarshal(dat, dbObj); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	return dbObj, nil
+}
+
+// Restore validates a snapshot written by Backup and replaces the whole
+// content of the database with it
+func (db *DB) Restore(r io.Reader) error {
+	dbObj, err := readSnapshot(r)
+	if err != nil {
+		return err
+	}
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	db.data = dbObj
+	db.idx = buildIndexes(dbObj)
+	db.resetCounters()
+	if db.path == "" {
+		return nil
+	}
+	return db.compact()
+}
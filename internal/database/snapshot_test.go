@@ -0,0 +1,82 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+func TestNewDBRefusesDatabaseInUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	db, err := NewDB(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewDB(path, false); !errors.Is(err, ErrDatabaseInUse) {
+		t.Fatalf("second open: got %v, want ErrDatabaseInUse", err)
+	}
+	db.Close()
+	reopened, err := NewDB(path, false)
+	if err != nil {
+		t.Fatalf("open after close: %v", err)
+	}
+	reopened.Close()
+}
+
+func TestReadDBLeavesFilesAlone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	db, err := NewDB(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	user, err := db.CreateUser("a@example.com", "hash", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if _, err := db.CreateChirp(entities.Chirp{UserId: user.Id, Body: "hello"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snapshot, _ := os.ReadFile(path)
+	journal, _ := os.ReadFile(path + ".journal")
+	if len(journal) == 0 {
+		t.Fatal("expected commits pending in the journal")
+	}
+
+	read, err := ReadDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chirps, err := read.GetChirps(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chirps) != 3 {
+		t.Fatalf("got %d chirps, want the 3 in the journal", len(chirps))
+	}
+	var out bytes.Buffer
+	if err := read.Backup(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	if after, _ := os.ReadFile(path); !bytes.Equal(after, snapshot) {
+		t.Error("ReadDB rewrote the snapshot")
+	}
+	if after, _ := os.ReadFile(path + ".journal"); !bytes.Equal(after, journal) {
+		t.Error("ReadDB changed the journal")
+	}
+
+	restored := NewMemoryDB()
+	if err := restored.Restore(&out); err != nil {
+		t.Fatal(err)
+	}
+	if chirps, _ := restored.GetChirps(nil); len(chirps) != 3 {
+		t.Fatalf("restored %d chirps, want 3", len(chirps))
+	}
+}
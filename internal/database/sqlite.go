@@ -0,0 +1,86 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLiteDB is a Store backed by a SQLite database file
+type SQLiteDB struct {
+	conn *sql.DB
+}
+
+var _ Store = (*SQLiteDB)(nil)
+
+// NewSQLiteDB opens the SQLite database at path, creating it if needed,
+// and applies any pending schema migrations.
+// With reset, any existing data is discarded first.
+func NewSQLiteDB(path string, reset bool) (*SQLiteDB, error) {
+	if reset {
+		if err := removeFiles(path, path+"-wal", path+"-shm"); err != nil {
+			return nil, err
+		}
+	}
+	// write transactions read first, such as the handle lookup of a signup:
+	// deferred ones would fail with SQLITE_BUSY when upgrading their lock,
+	// immediate ones wait for the write lock under busy_timeout
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_txlock=immediate", path)
+	conn, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db := &SQLiteDB{conn: conn}
+	if err := db.migrate(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Close releases the underlying database connections
+func (db *SQLiteDB) Close() error {
+	return db.conn.Close()
+}
+
+// migrate applies every migration newer than the schema's user_version,
+// each one in its own transaction
+func (db *SQLiteDB) migrate() error {
+	var version int
+	if err := db.conn.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	for i := version; i < len(sqliteMigrations); i++ {
+		tx, err := db.conn.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(sqliteMigrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("sqlite migration %d: %w", i+1, err)
+		}
+		if backfill, ok := sqliteBackfills[i+1]; ok {
+			if err := backfill(tx); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("sqlite migration %d: %w", i+1, err)
+			}
+		}
+		// PRAGMA does not accept bound parameters
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"time"
+
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+// excludedAuthors selects the users whose chirps a viewer does not see,
+// see Tx.ExcludedAuthors. It takes the viewer id three times.
+const excludedAuthors = `SELECT blocked_id FROM blocks WHERE blocker_id = ?
+	UNION SELECT blocker_id FROM blocks WHERE blocked_id = ?
+	UNION SELECT muted_id FROM mutes WHERE muter_id = ?`
+
+// blocks tells whether blockerId blocks userId
+func blocks(q rowQueryer, blockerId, userId int) (bool, error) {
+	var found bool
+	err := q.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM blocks WHERE blocker_id = ? AND blocked_id = ?)",
+		blockerId, userId,
+	).Scan(&found)
+	return found, err
+}
+
+// Block is an idempotent operation that makes blockerId block userId.
+// Any follow between the two users is dropped.
+func (db *SQLiteDB) Block(blockerId, userId int) error {
+	if blockerId == userId {
+		return ErrSelfBlock
+	}
+	if _, err := db.GetUserByID(userId); err != nil {
+		return err
+	}
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(
+		"DELETE FROM follows WHERE (follower_id = ? AND followee_id = ?) OR (follower_id = ? AND followee_id = ?)",
+		blockerId, userId, userId, blockerId,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO blocks (blocker_id, blocked_id, created_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+		blockerId, userId, time.Now().UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Unblock is an idempotent operation that withdraws the block of userId by blockerId
+func (db *SQLiteDB) Unblock(blockerId, userId int) error {
+	_, err := db.conn.Exec("DELETE FROM blocks WHERE blocker_id = ? AND blocked_id = ?", blockerId, userId)
+	return err
+}
+
+// Mute is an idempotent operation that hides the chirps of userId from muterId
+func (db *SQLiteDB) Mute(muterId, userId int) error {
+	if muterId == userId {
+		return ErrSelfBlock
+	}
+	if _, err := db.GetUserByID(userId); err != nil {
+		return err
+	}
+	_, err := db.conn.Exec(
+		"INSERT INTO mutes (muter_id, muted_id, created_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+		muterId, userId, time.Now().UTC(),
+	)
+	return err
+}
+
+// Unmute is an idempotent operation that withdraws the mute of userId by muterId
+func (db *SQLiteDB) Unmute(muterId, userId int) error {
+	_, err := db.conn.Exec("DELETE FROM mutes WHERE muter_id = ? AND muted_id = ?", muterId, userId)
+	return err
+}
+
+// GetBlocked returns the users blocked by userId
+func (db *SQLiteDB) GetBlocked(userId int) ([]entities.User, error) {
+	if _, err := db.GetUserByID(userId); err != nil {
+		return nil, err
+	}
+	return db.queryUsers(
+		"SELECT "+userColumns+" FROM users WHERE id IN (SELECT blocked_id FROM blocks WHERE blocker_id = ?) ORDER BY id",
+		userId,
+	)
+}
+
+// GetMuted returns the users muted by userId
+func (db *SQLiteDB) GetMuted(userId int) ([]entities.User, error) {
+	if _, err := db.GetUserByID(userId); err != nil {
+		return nil, err
+	}
+	return db.queryUsers(
+		"SELECT "+userColumns+" FROM users WHERE id IN (SELECT muted_id FROM mutes WHERE muter_id = ?) ORDER BY id",
+		userId,
+	)
+}
@@ -0,0 +1,421 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/sp3dr4/chirpy/internal/entities"
+	"github.com/sp3dr4/chirpy/internal/search"
+)
+
+const chirpColumns = "id, body, author_id, created_at, updated_at, in_reply_to, root_id, hashtags, mentions, flags, hidden, visibility"
+
+// listedChirp filters out hidden chirps and the ones by suspended authors,
+// see Tx.Listed
+const listedChirp = "chirps.hidden = 0 AND chirps.author_id NOT IN (SELECT id FROM users WHERE suspended = 1)"
+
+// readableChirp returns the condition keeping the chirps viewerId may
+// read regardless of blocks and mutes, see Tx.Readable, and its arguments
+func readableChirp(viewerId *int) (string, []any) {
+	if viewerId == nil {
+		return listedChirp + " AND chirps.visibility = 'public'", nil
+	}
+	return listedChirp + ` AND (chirps.visibility = 'public' OR chirps.author_id = ?
+		OR (chirps.visibility = 'followers' AND chirps.author_id IN (SELECT followee_id FROM follows WHERE follower_id = ?))
+		OR (chirps.visibility = 'direct' AND chirps.id IN (SELECT chirp_id FROM chirp_mentions WHERE user_id = ?)))`,
+		[]any{*viewerId, *viewerId, *viewerId}
+}
+
+// visibleChirp returns the condition keeping the chirps viewerId sees in
+// listings, see Tx.ChirpFilter, and its arguments
+func visibleChirp(viewerId *int) (string, []any) {
+	filter, args := readableChirp(viewerId)
+	if viewerId == nil {
+		return filter, args
+	}
+	return filter + " AND chirps.author_id NOT IN (" + excludedAuthors + ")", append(args, *viewerId, *viewerId, *viewerId)
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// extraScanner scans the columns following the ones of a scan function
+// into extra, for queries selecting more than a single entity
+type extraScanner struct {
+	row   rowScanner
+	extra []any
+}
+
+func (s extraScanner) Scan(dest ...any) error {
+	return s.row.Scan(append(dest, s.extra...)...)
+}
+
+func scanChirp(row rowScanner) (*entities.Chirp, error) {
+	var c entities.Chirp
+	var inReplyTo, rootId sql.NullInt64
+	var hashtags, mentions, flags string
+	if err := row.Scan(&c.Id, &c.Body, &c.UserId, &c.CreatedAt, &c.UpdatedAt, &inReplyTo, &rootId, &hashtags, &mentions, &flags, &c.Hidden, &c.Visibility); err != nil {
+		return nil, err
+	}
+	c.InReplyTo = nullableInt(inReplyTo)
+	c.RootId = nullableInt(rootId)
+	if err := json.Unmarshal([]byte(hashtags), &c.Hashtags); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(mentions), &c.Mentions); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(flags), &c.Flags); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// jsonList encodes a list column, storing nil lists as empty ones
+func jsonList[T any](list []T) string {
+	if list == nil {
+		return "[]"
+	}
+	dat, _ := json.Marshal(list)
+	return string(dat)
+}
+
+// saveChirpEntities stores the hashtags and mentions parsed from the body
+// of a chirp, replacing the previous ones
+func saveChirpEntities(tx *sql.Tx, chirpId int, hashtags []string, mentions []entities.Mention) error {
+	_, err := tx.Exec("UPDATE chirps SET hashtags = ?, mentions = ? WHERE id = ?", jsonList(hashtags), jsonList(mentions), chirpId)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM chirp_hashtags WHERE chirp_id = ?", chirpId); err != nil {
+		return err
+	}
+	for _, tag := range hashtags {
+		if _, err = tx.Exec("INSERT INTO chirp_hashtags (chirp_id, tag) VALUES (?, ?)", chirpId, tag); err != nil {
+			return err
+		}
+	}
+	if _, err = tx.Exec("DELETE FROM chirp_mentions WHERE chirp_id = ?", chirpId); err != nil {
+		return err
+	}
+	for _, m := range mentions {
+		if _, err = tx.Exec("INSERT OR IGNORE INTO chirp_mentions (chirp_id, user_id) VALUES (?, ?)", chirpId, m.UserId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// userIdByHandle looks up handles within tx, for resolveMentions
+func userIdByHandle(tx *sql.Tx) func(handle string) (int, bool) {
+	return func(handle string) (int, bool) {
+		var id int
+		if err := tx.QueryRow("SELECT id FROM users WHERE handle = ?", handle).Scan(&id); err != nil {
+			return 0, false
+		}
+		return id, true
+	}
+}
+
+func nullableInt(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	i := int(v.Int64)
+	return &i
+}
+
+func (db *SQLiteDB) queryChirps(query string, args ...any) ([]entities.Chirp, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	chirps := []entities.Chirp{}
+	for rows.Next() {
+		c, err := scanChirp(rows)
+		if err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, *c)
+	}
+	return chirps, rows.Err()
+}
+
+// CreateChirp saves a new chirp, assigning its id, timestamps and,
+// for replies, the root of the conversation. Flagged chirps are
+// queued for review. Chirps are public unless set otherwise, direct
+// ones must mention a known user.
+func (db *SQLiteDB) CreateChirp(chirp entities.Chirp) (*entities.Chirp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if chirp.Visibility == "" {
+		chirp.Visibility = entities.VisibilityPublic
+	}
+	chirp.RootId = nil
+	if chirp.InReplyTo != nil {
+		filter, args := readableChirp(&chirp.UserId)
+		parent, err := scanChirp(tx.QueryRow("SELECT "+chirpColumns+" FROM chirps WHERE id = ? AND "+filter, append([]any{*chirp.InReplyTo}, args...)...))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrParentNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		blocked, err := blocks(tx, parent.UserId, chirp.UserId)
+		if err != nil {
+			return nil, err
+		}
+		if blocked {
+			return nil, ErrBlocked
+		}
+		rootId := parent.ThreadRootId()
+		chirp.RootId = &rootId
+	}
+	now := time.Now().UTC()
+	res, err := tx.Exec(
+		"INSERT INTO chirps (body, author_id, created_at, updated_at, in_reply_to, root_id, flags, visibility) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		chirp.Body, chirp.UserId, now, now, chirp.InReplyTo, chirp.RootId, jsonList(chirp.Flags), chirp.Visibility,
+	)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	chirp.Hashtags = entities.ParseHashtags(chirp.Body)
+	chirp.Mentions = resolveMentions(chirp.Body, userIdByHandle(tx))
+	if chirp.Visibility == entities.VisibilityDirect && len(chirp.Mentions) == 0 {
+		return nil, ErrNoRecipients
+	}
+	if err := saveChirpEntities(tx, int(id), chirp.Hashtags, chirp.Mentions); err != nil {
+		return nil, err
+	}
+	chirp.Id = int(id)
+	chirp.CreatedAt = now
+	chirp.UpdatedAt = now
+	if err := saveFlagReport(tx, chirp); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &chirp, nil
+}
+
+// GetChirps returns all chirps in the database
+func (db *SQLiteDB) GetChirps(userId *int) ([]entities.Chirp, error) {
+	if userId != nil {
+		return db.queryChirps("SELECT "+chirpColumns+" FROM chirps WHERE author_id = ?", *userId)
+	}
+	return db.queryChirps("SELECT " + chirpColumns + " FROM chirps")
+}
+
+// ListChirps returns the page of chirps selected by q
+// and whether more chirps follow it
+func (db *SQLiteDB) ListChirps(q ChirpQuery) ([]entities.Chirp, bool, error) {
+	filter, args := visibleChirp(q.ViewerId)
+	query := "SELECT " + chirpColumns + " FROM chirps WHERE " + filter
+	if q.Inbox {
+		if q.ViewerId == nil {
+			return []entities.Chirp{}, false, nil
+		}
+		query += " AND visibility = 'direct' AND id IN (SELECT chirp_id FROM chirp_mentions WHERE user_id = ?)"
+		args = append(args, *q.ViewerId)
+	} else if q.AuthorId != nil {
+		query += " AND author_id = ?"
+		args = append(args, *q.AuthorId)
+	} else if q.Hashtag != "" {
+		query += " AND id IN (SELECT chirp_id FROM chirp_hashtags WHERE tag = ?)"
+		args = append(args, q.Hashtag)
+	}
+	order := "ASC"
+	if q.Desc {
+		order = "DESC"
+	}
+	if q.AfterId != 0 {
+		if q.Desc {
+			query += " AND id < ?"
+		} else {
+			query += " AND id > ?"
+		}
+		args = append(args, q.AfterId)
+	}
+	// one extra row tells whether there is a next page
+	query += " ORDER BY id " + order + " LIMIT ?"
+	args = append(args, q.Limit+1)
+	chirps, err := db.queryChirps(query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(chirps) > q.Limit {
+		return chirps[:q.Limit], true, nil
+	}
+	return chirps, false, nil
+}
+
+// SearchChirps returns up to q.Limit chirps matching q, most relevant first
+func (db *SQLiteDB) SearchChirps(q SearchQuery) ([]entities.Chirp, error) {
+	parsed := search.ParseQuery(q.Text)
+	if parsed.IsEmpty() {
+		return nil, ErrEmptySearch
+	}
+	query := "SELECT " + chirpColumns + " FROM chirps JOIN (" +
+		"SELECT rowid, bm25(chirps_fts) AS score FROM chirps_fts WHERE chirps_fts MATCH ?" +
+		") AS matches ON matches.rowid = chirps.id WHERE "
+	filter, filterArgs := visibleChirp(q.ViewerId)
+	query += filter
+	args := append([]any{ftsMatch(parsed)}, filterArgs...)
+	if q.AuthorId != nil {
+		query += " AND author_id = ?"
+		args = append(args, *q.AuthorId)
+	}
+	// bm25 scores are negative, the best match comes first
+	query += " ORDER BY score, id DESC LIMIT ?"
+	args = append(args, q.Limit)
+	return db.queryChirps(query, args...)
+}
+
+// ftsMatch renders q as an FTS5 query. Terms only contain letters and
+// digits, quoting them keeps FTS5 operators such as NOT out of the query.
+func ftsMatch(q search.Query) string {
+	parts := []string{}
+	for _, term := range q.Terms {
+		parts = append(parts, `"`+term+`"`)
+	}
+	for _, phrase := range q.Phrases {
+		parts = append(parts, `"`+strings.Join(phrase, " ")+`"`)
+	}
+	return strings.Join(parts, " AND ")
+}
+
+// GetChirpByID returns the chirp with the given id
+func (db *SQLiteDB) GetChirpByID(id int) (*entities.Chirp, error) {
+	c, err := scanChirp(db.conn.QueryRow("SELECT "+chirpColumns+" FROM chirps WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrChirpNotFound
+	}
+	return c, err
+}
+
+// getReadableChirp returns the chirp with the given id when viewerId may
+// read it, see readableChirp
+func (db *SQLiteDB) getReadableChirp(id int, viewerId *int) (*entities.Chirp, error) {
+	filter, args := readableChirp(viewerId)
+	c, err := scanChirp(db.conn.QueryRow("SELECT "+chirpColumns+" FROM chirps WHERE id = ? AND "+filter, append([]any{id}, args...)...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrChirpNotFound
+	}
+	return c, err
+}
+
+// GetVisibleChirp returns the chirp with the given id if viewerId may read
+// it. Authors always find their own chirps, even hidden ones.
+func (db *SQLiteDB) GetVisibleChirp(id int, viewerId *int) (*entities.Chirp, error) {
+	if viewerId != nil {
+		c, err := db.GetChirpByID(id)
+		if err != nil || c.UserId == *viewerId {
+			return c, err
+		}
+	}
+	filter, args := visibleChirp(viewerId)
+	c, err := scanChirp(db.conn.QueryRow("SELECT "+chirpColumns+" FROM chirps WHERE id = ? AND "+filter, append([]any{id}, args...)...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrChirpNotFound
+	}
+	return c, err
+}
+
+// UpdateChirp replaces the body of a chirp and its moderation flags,
+// keeping the previous body in its history. Flagged chirps are
+// queued for review. Direct chirps must keep mentioning a known user.
+func (db *SQLiteDB) UpdateChirp(id int, body string, flags []string) (*entities.Chirp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	chirp, err := scanChirp(tx.QueryRow("SELECT "+chirpColumns+" FROM chirps WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrChirpNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	_, err = tx.Exec(
+		"INSERT INTO chirp_revisions (chirp_id, body, posted_at, edited_at) VALUES (?, ?, ?, ?)",
+		id, chirp.Body, chirp.UpdatedAt, now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec("UPDATE chirps SET body = ?, flags = ?, updated_at = ? WHERE id = ?", body, jsonList(flags), now, id)
+	if err != nil {
+		return nil, err
+	}
+	chirp.Hashtags = entities.ParseHashtags(body)
+	chirp.Mentions = resolveMentions(body, userIdByHandle(tx))
+	if chirp.Visibility == entities.VisibilityDirect && len(chirp.Mentions) == 0 {
+		return nil, ErrNoRecipients
+	}
+	if err = saveChirpEntities(tx, id, chirp.Hashtags, chirp.Mentions); err != nil {
+		return nil, err
+	}
+	chirp.Body = body
+	chirp.Flags = flags
+	chirp.UpdatedAt = now
+	if err = saveFlagReport(tx, *chirp); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return chirp, nil
+}
+
+// GetChirpHistory returns the previous bodies of a chirp, oldest first
+func (db *SQLiteDB) GetChirpHistory(id int) ([]entities.ChirpRevision, error) {
+	if _, err := db.GetChirpByID(id); err != nil {
+		return nil, err
+	}
+	rows, err := db.conn.Query(
+		"SELECT chirp_id, body, posted_at, edited_at FROM chirp_revisions WHERE chirp_id = ? ORDER BY id",
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	revisions := []entities.ChirpRevision{}
+	for rows.Next() {
+		var r entities.ChirpRevision
+		if err := rows.Scan(&r.ChirpId, &r.Body, &r.PostedAt, &r.EditedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, rows.Err()
+}
+
+// GetThread returns every chirp of the conversation started by rootId
+// that viewerId may read, including the root unless it was deleted
+func (db *SQLiteDB) GetThread(rootId int, viewerId *int) ([]entities.Chirp, error) {
+	filter, args := visibleChirp(viewerId)
+	return db.queryChirps("SELECT "+chirpColumns+" FROM chirps WHERE (id = ? OR root_id = ?) AND "+filter, append([]any{rootId, rootId}, args...)...)
+}
+
+// DeleteChirp is an idempotent operation that deletes a chirp by id.
+func (db *SQLiteDB) DeleteChirp(id int) error {
+	_, err := db.conn.Exec("DELETE FROM chirps WHERE id = ?", id)
+	return err
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"time"
+
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+// Follow is an idempotent operation that makes followerId follow followeeId
+func (db *SQLiteDB) Follow(followerId, followeeId int) error {
+	if followerId == followeeId {
+		return ErrSelfFollow
+	}
+	if _, err := db.GetUserByID(followeeId); err != nil {
+		return err
+	}
+	blocked, err := blocks(db.conn, followeeId, followerId)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return ErrBlocked
+	}
+	_, err = db.conn.Exec(
+		"INSERT INTO follows (follower_id, followee_id, created_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+		followerId, followeeId, time.Now().UTC(),
+	)
+	return err
+}
+
+// Unfollow is an idempotent operation that makes followerId stop following followeeId
+func (db *SQLiteDB) Unfollow(followerId, followeeId int) error {
+	_, err := db.conn.Exec("DELETE FROM follows WHERE follower_id = ? AND followee_id = ?", followerId, followeeId)
+	return err
+}
+
+// GetFollowers returns the users following userId
+func (db *SQLiteDB) GetFollowers(userId int) ([]entities.User, error) {
+	if _, err := db.GetUserByID(userId); err != nil {
+		return nil, err
+	}
+	return db.queryUsers(
+		"SELECT "+userColumns+" FROM users WHERE id IN (SELECT follower_id FROM follows WHERE followee_id = ?) ORDER BY id",
+		userId,
+	)
+}
+
+// GetFollowing returns the users followed by userId
+func (db *SQLiteDB) GetFollowing(userId int) ([]entities.User, error) {
+	if _, err := db.GetUserByID(userId); err != nil {
+		return nil, err
+	}
+	return db.queryUsers(
+		"SELECT "+userColumns+" FROM users WHERE id IN (SELECT followee_id FROM follows WHERE follower_id = ?) ORDER BY id",
+		userId,
+	)
+}
+
+// GetTimeline returns the chirps readable by userId posted or rechirped
+// by the users they follow, newest first, leaving out the followees
+// excluded for userId. A chirp appears once, at its latest entry.
+func (db *SQLiteDB) GetTimeline(userId int) ([]entities.TimelineItem, error) {
+	filter, filterArgs := visibleChirp(&userId)
+	chirps, err := db.queryChirps(
+		"SELECT "+chirpColumns+" FROM chirps WHERE author_id IN (SELECT followee_id FROM follows WHERE follower_id = ?) AND "+filter,
+		append([]any{userId}, filterArgs...)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]entities.TimelineItem, 0, len(chirps))
+	for _, c := range chirps {
+		items = append(items, entities.TimelineItem{Chirp: c})
+	}
+
+	rows, err := db.conn.Query(
+		"SELECT "+chirpColumns+", r.rechirp_id, r.rechirp_user_id, r.rechirped_at FROM chirps JOIN ("+
+			"SELECT id AS rechirp_id, chirp_id AS rechirped_id, user_id AS rechirp_user_id, created_at AS rechirped_at FROM rechirps"+
+			" WHERE user_id IN (SELECT followee_id FROM follows WHERE follower_id = ?)"+
+			" AND user_id NOT IN ("+excludedAuthors+")"+
+			") AS r ON r.rechirped_id = chirps.id WHERE "+filter,
+		append([]any{userId, userId, userId, userId}, filterArgs...)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		r := entities.Rechirp{}
+		c, err := scanChirp(extraScanner{rows, []any{&r.Id, &r.UserId, &r.CreatedAt}})
+		if err != nil {
+			return nil, err
+		}
+		r.ChirpId = c.Id
+		items = append(items, entities.TimelineItem{Chirp: *c, Rechirp: &r})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return latestTimelineItems(items), nil
+}
@@ -0,0 +1,31 @@
+package database
+
+import (
+	"time"
+
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+// TrendingHashtags returns the limit hashtags used by the most public
+// chirps created since the given time, most used first
+func (db *SQLiteDB) TrendingHashtags(since time.Time, limit int) ([]entities.HashtagCount, error) {
+	public, _ := readableChirp(nil)
+	rows, err := db.conn.Query(
+		`SELECT h.tag, COUNT(*) AS uses FROM chirp_hashtags h JOIN chirps ON chirps.id = h.chirp_id
+		WHERE chirps.created_at >= ? AND `+public+` GROUP BY h.tag ORDER BY uses DESC, h.tag LIMIT ?`,
+		since.UTC(), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	trending := []entities.HashtagCount{}
+	for rows.Next() {
+		var h entities.HashtagCount
+		if err := rows.Scan(&h.Tag, &h.Count); err != nil {
+			return nil, err
+		}
+		trending = append(trending, h)
+	}
+	return trending, rows.Err()
+}
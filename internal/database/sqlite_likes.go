@@ -0,0 +1,103 @@
+package database
+
+import (
+	"strings"
+	"time"
+
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+// Like is an idempotent operation that makes userId like chirpId
+func (db *SQLiteDB) Like(userId, chirpId int) error {
+	if _, err := db.checkEngagement(userId, chirpId); err != nil {
+		return err
+	}
+	_, err := db.conn.Exec(
+		"INSERT INTO likes (chirp_id, user_id, created_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+		chirpId, userId, time.Now().UTC(),
+	)
+	return err
+}
+
+// checkEngagement returns chirpId, failing unless userId may like or rechirp it
+func (db *SQLiteDB) checkEngagement(userId, chirpId int) (*entities.Chirp, error) {
+	chirp, err := db.getReadableChirp(chirpId, &userId)
+	if err != nil {
+		return nil, err
+	}
+	blocked, err := blocks(db.conn, chirp.UserId, userId)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return nil, ErrBlocked
+	}
+	return chirp, nil
+}
+
+// Unlike is an idempotent operation that withdraws the like of userId on chirpId
+func (db *SQLiteDB) Unlike(userId, chirpId int) error {
+	_, err := db.conn.Exec("DELETE FROM likes WHERE chirp_id = ? AND user_id = ?", chirpId, userId)
+	return err
+}
+
+// Rechirp is an idempotent operation that makes userId share chirpId with their followers.
+// Only public chirps can be rechirped.
+func (db *SQLiteDB) Rechirp(userId, chirpId int) error {
+	chirp, err := db.checkEngagement(userId, chirpId)
+	if err != nil {
+		return err
+	}
+	if chirp.Visibility != entities.VisibilityPublic {
+		return ErrPrivateChirp
+	}
+	_, err = db.conn.Exec(
+		"INSERT INTO rechirps (chirp_id, user_id, created_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+		chirpId, userId, time.Now().UTC(),
+	)
+	return err
+}
+
+// Unrechirp is an idempotent operation that withdraws the rechirp of chirpId by userId
+func (db *SQLiteDB) Unrechirp(userId, chirpId int) error {
+	_, err := db.conn.Exec("DELETE FROM rechirps WHERE chirp_id = ? AND user_id = ?", chirpId, userId)
+	return err
+}
+
+// GetChirpStats counts the likes and rechirps of the given chirps,
+// reporting whether viewerId liked them when not nil
+func (db *SQLiteDB) GetChirpStats(chirpIds []int, viewerId *int) (map[int]entities.ChirpStats, error) {
+	stats := map[int]entities.ChirpStats{}
+	if len(chirpIds) == 0 {
+		return stats, nil
+	}
+	viewer := 0
+	if viewerId != nil {
+		viewer = *viewerId
+	}
+	args := []any{viewer}
+	for _, id := range chirpIds {
+		args = append(args, id)
+	}
+	rows, err := db.conn.Query(
+		`SELECT c.id,
+			(SELECT COUNT(*) FROM likes WHERE chirp_id = c.id),
+			(SELECT COUNT(*) FROM rechirps WHERE chirp_id = c.id),
+			EXISTS (SELECT 1 FROM likes WHERE chirp_id = c.id AND user_id = ?)
+		FROM chirps c WHERE c.id IN (?`+strings.Repeat(", ?", len(chirpIds)-1)+`)`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var s entities.ChirpStats
+		if err := rows.Scan(&id, &s.LikeCount, &s.RechirpCount, &s.LikedByMe); err != nil {
+			return nil, err
+		}
+		stats[id] = s
+	}
+	return stats, rows.Err()
+}
@@ -0,0 +1,380 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+// sqliteMigrations are applied in order; the schema version stored in
+// PRAGMA user_version is the number of migrations already applied.
+// Never edit a released migration, append a new one instead.
+var sqliteMigrations = []string{
+	// 1: initial schema
+	`
+	CREATE TABLE users (
+		id            INTEGER PRIMARY KEY AUTOINCREMENT,
+		email         TEXT    NOT NULL,
+		password      TEXT    NOT NULL,
+		is_chirpy_red INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE UNIQUE INDEX idx_users_email ON users(email);
+
+	CREATE TABLE chirps (
+		id        INTEGER PRIMARY KEY AUTOINCREMENT,
+		body      TEXT    NOT NULL,
+		author_id INTEGER NOT NULL REFERENCES users(id)
+	);
+	CREATE INDEX idx_chirps_author_id ON chirps(author_id);
+
+	CREATE TABLE refresh_tokens (
+		user_id    INTEGER   PRIMARY KEY REFERENCES users(id),
+		token      TEXT      NOT NULL,
+		expires_at TIMESTAMP NOT NULL
+	);
+	CREATE UNIQUE INDEX idx_refresh_tokens_token ON refresh_tokens(token);
+	`,
+	// 2: chirp timestamps and revisions
+	`
+	ALTER TABLE chirps ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00';
+	ALTER TABLE chirps ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00';
+	UPDATE chirps SET created_at = datetime('now'), updated_at = datetime('now');
+
+	CREATE TABLE chirp_revisions (
+		id        INTEGER   PRIMARY KEY AUTOINCREMENT,
+		chirp_id  INTEGER   NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+		body      TEXT      NOT NULL,
+		posted_at TIMESTAMP NOT NULL,
+		edited_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX idx_chirp_revisions_chirp_id ON chirp_revisions(chirp_id);
+	`,
+	// 3: chirp replies, without foreign keys so that replies
+	// keep pointing to their deleted parents
+	`
+	ALTER TABLE chirps ADD COLUMN in_reply_to INTEGER;
+	ALTER TABLE chirps ADD COLUMN root_id INTEGER;
+	CREATE INDEX idx_chirps_root_id ON chirps(root_id);
+	`,
+	// 4: follows
+	`
+	CREATE TABLE follows (
+		follower_id INTEGER   NOT NULL REFERENCES users(id),
+		followee_id INTEGER   NOT NULL REFERENCES users(id),
+		created_at  TIMESTAMP NOT NULL,
+		PRIMARY KEY (follower_id, followee_id)
+	);
+	CREATE INDEX idx_follows_followee_id ON follows(followee_id);
+	`,
+	// 5: full-text index over chirp bodies, kept in sync by triggers
+	`
+	CREATE VIRTUAL TABLE chirps_fts USING fts5(
+		body,
+		content = 'chirps',
+		content_rowid = 'id',
+		tokenize = 'unicode61 remove_diacritics 0'
+	);
+	INSERT INTO chirps_fts(chirps_fts) VALUES ('rebuild');
+
+	CREATE TRIGGER chirps_fts_insert AFTER INSERT ON chirps BEGIN
+		INSERT INTO chirps_fts(rowid, body) VALUES (new.id, new.body);
+	END;
+	CREATE TRIGGER chirps_fts_delete AFTER DELETE ON chirps BEGIN
+		INSERT INTO chirps_fts(chirps_fts, rowid, body) VALUES ('delete', old.id, old.body);
+	END;
+	CREATE TRIGGER chirps_fts_update AFTER UPDATE OF body ON chirps BEGIN
+		INSERT INTO chirps_fts(chirps_fts, rowid, body) VALUES ('delete', old.id, old.body);
+		INSERT INTO chirps_fts(rowid, body) VALUES (new.id, new.body);
+	END;
+	`,
+	// 6: user handles, chirp hashtags and mentions,
+	// existing rows are filled in by sqliteBackfills
+	`
+	ALTER TABLE users ADD COLUMN handle TEXT NOT NULL DEFAULT '';
+	ALTER TABLE chirps ADD COLUMN hashtags TEXT NOT NULL DEFAULT '[]';
+	ALTER TABLE chirps ADD COLUMN mentions TEXT NOT NULL DEFAULT '[]';
+	CREATE INDEX idx_chirps_created_at ON chirps(created_at);
+
+	CREATE TABLE chirp_hashtags (
+		chirp_id INTEGER NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+		tag      TEXT    NOT NULL,
+		PRIMARY KEY (tag, chirp_id)
+	);
+	CREATE INDEX idx_chirp_hashtags_chirp_id ON chirp_hashtags(chirp_id);
+	`,
+	// 7: unique handles, once backfilled
+	`
+	CREATE UNIQUE INDEX idx_users_handle ON users(handle);
+	`,
+	// 8: likes and rechirps, gone along with their chirp
+	`
+	CREATE TABLE likes (
+		chirp_id   INTEGER   NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+		user_id    INTEGER   NOT NULL REFERENCES users(id),
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (chirp_id, user_id)
+	);
+	CREATE INDEX idx_likes_user_id ON likes(user_id);
+
+	CREATE TABLE rechirps (
+		id         INTEGER   PRIMARY KEY AUTOINCREMENT,
+		chirp_id   INTEGER   NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+		user_id    INTEGER   NOT NULL REFERENCES users(id),
+		created_at TIMESTAMP NOT NULL
+	);
+	CREATE UNIQUE INDEX idx_rechirps_user_id_chirp_id ON rechirps(user_id, chirp_id);
+	CREATE INDEX idx_rechirps_chirp_id ON rechirps(chirp_id);
+	`,
+	// 9: moderation flags
+	`
+	ALTER TABLE chirps ADD COLUMN flags TEXT NOT NULL DEFAULT '[]';
+	`,
+	// 10: reports, hidden chirps and suspended users. Reports have no
+	// foreign key on chirps so that they outlive the reported chirps.
+	`
+	ALTER TABLE chirps ADD COLUMN hidden INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE users ADD COLUMN suspended INTEGER NOT NULL DEFAULT 0;
+
+	CREATE TABLE reports (
+		id          INTEGER   PRIMARY KEY AUTOINCREMENT,
+		reporter_id INTEGER   REFERENCES users(id),
+		chirp_id    INTEGER,
+		user_id     INTEGER   NOT NULL REFERENCES users(id),
+		reason      TEXT      NOT NULL,
+		status      TEXT      NOT NULL,
+		created_at  TIMESTAMP NOT NULL,
+		resolved_at TIMESTAMP
+	);
+	CREATE INDEX idx_reports_status ON reports(status);
+	CREATE INDEX idx_reports_chirp_id ON reports(chirp_id);
+	CREATE INDEX idx_reports_user_id ON reports(user_id);
+	`,
+	// 11: blocks and mutes
+	`
+	CREATE TABLE blocks (
+		blocker_id INTEGER   NOT NULL REFERENCES users(id),
+		blocked_id INTEGER   NOT NULL REFERENCES users(id),
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (blocker_id, blocked_id)
+	);
+	CREATE INDEX idx_blocks_blocked_id ON blocks(blocked_id);
+
+	CREATE TABLE mutes (
+		muter_id   INTEGER   NOT NULL REFERENCES users(id),
+		muted_id   INTEGER   NOT NULL REFERENCES users(id),
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (muter_id, muted_id)
+	);
+	`,
+	// 12: chirp visibility, existing chirps are public, and the users
+	// a chirp mentions, gone along with it, to find direct recipients
+	`
+	ALTER TABLE chirps ADD COLUMN visibility TEXT NOT NULL DEFAULT 'public';
+
+	CREATE TABLE chirp_mentions (
+		chirp_id INTEGER NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+		user_id  INTEGER NOT NULL,
+		PRIMARY KEY (user_id, chirp_id)
+	);
+	CREATE INDEX idx_chirp_mentions_chirp_id ON chirp_mentions(chirp_id);
+	INSERT OR IGNORE INTO chirp_mentions (chirp_id, user_id)
+		SELECT chirps.id, json_extract(m.value, '$.user_id') FROM chirps, json_each(chirps.mentions) AS m;
+	`,
+	// 13: sessions, replacing the single refresh token of each user
+	`
+	CREATE TABLE sessions (
+		id           INTEGER   PRIMARY KEY AUTOINCREMENT,
+		user_id      INTEGER   NOT NULL REFERENCES users(id),
+		token_hash   TEXT      NOT NULL,
+		user_agent   TEXT      NOT NULL,
+		ip           TEXT      NOT NULL,
+		created_at   TIMESTAMP NOT NULL,
+		last_used_at TIMESTAMP NOT NULL,
+		expires_at   TIMESTAMP NOT NULL
+	);
+	CREATE UNIQUE INDEX idx_sessions_token_hash ON sessions(token_hash);
+	CREATE INDEX idx_sessions_user_id ON sessions(user_id);
+	`,
+	// 14: refresh token rotation, retired tokens are gone along with
+	// their session, and security events
+	`
+	CREATE TABLE retired_tokens (
+		token_hash TEXT    PRIMARY KEY,
+		session_id INTEGER NOT NULL REFERENCES sessions(id) ON DELETE CASCADE
+	);
+	CREATE INDEX idx_retired_tokens_session_id ON retired_tokens(session_id);
+
+	CREATE TABLE security_events (
+		id         INTEGER   PRIMARY KEY AUTOINCREMENT,
+		user_id    INTEGER   NOT NULL REFERENCES users(id),
+		kind       TEXT      NOT NULL,
+		session_id INTEGER   NOT NULL,
+		ip         TEXT      NOT NULL,
+		user_agent TEXT      NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX idx_security_events_user_id ON security_events(user_id);
+	`,
+	// 15: keyed token hashes, the sessions with unkeyed ones are dropped
+	// along with their retired tokens. Databases older than 13 lose the
+	// sessions it converted from refresh tokens here too, their users
+	// log in again like everyone else.
+	`
+	DELETE FROM sessions;
+	`,
+	// 16: access tokens, gone along with their session, and the
+	// denylist of the revoked ones
+	`
+	CREATE TABLE access_tokens (
+		id         TEXT      PRIMARY KEY,
+		session_id INTEGER   NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+		expires_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX idx_access_tokens_session_id ON access_tokens(session_id);
+
+	CREATE TABLE revoked_tokens (
+		id         TEXT      PRIMARY KEY,
+		expires_at TIMESTAMP NOT NULL
+	);
+	`,
+	// 17: session expirations in UTC, like every other timestamp, so
+	// that they compare with them; rewritten by sqliteBackfills
+	``,
+}
+
+// sqliteBackfills run in the transaction of the migration with the same
+// number, after its statements, for data changes that need Go code
+var sqliteBackfills = map[int]func(tx *sql.Tx) error{
+	6:  backfillHandlesAndHashtags,
+	13: backfillSessions,
+	17: backfillSessionExpirations,
+}
+
+// backfillHandlesAndHashtags mirrors the JSON migration 5
+func backfillHandlesAndHashtags(tx *sql.Tx) error {
+	users, err := tx.Query("SELECT id, email FROM users ORDER BY id")
+	if err != nil {
+		return err
+	}
+	handles := map[string]int{}
+	byId := map[int]string{}
+	for users.Next() {
+		var id int
+		var email string
+		if err := users.Scan(&id, &email); err != nil {
+			users.Close()
+			return err
+		}
+		handle := uniqueHandle(entities.HandleFromEmail(email), func(h string) bool {
+			_, taken := handles[h]
+			return taken
+		})
+		handles[handle] = id
+		byId[id] = handle
+	}
+	users.Close()
+	if err := users.Err(); err != nil {
+		return err
+	}
+	for id, handle := range byId {
+		if _, err := tx.Exec("UPDATE users SET handle = ? WHERE id = ?", handle, id); err != nil {
+			return err
+		}
+	}
+
+	chirps, err := tx.Query("SELECT id, body FROM chirps")
+	if err != nil {
+		return err
+	}
+	bodies := map[int]string{}
+	for chirps.Next() {
+		var id int
+		var body string
+		if err := chirps.Scan(&id, &body); err != nil {
+			chirps.Close()
+			return err
+		}
+		bodies[id] = body
+	}
+	chirps.Close()
+	if err := chirps.Err(); err != nil {
+		return err
+	}
+	for id, body := range bodies {
+		hashtags := entities.ParseHashtags(body)
+		mentions := resolveMentions(body, func(h string) (int, bool) {
+			id, found := handles[h]
+			return id, found
+		})
+		if err := saveChirpEntities(tx, id, hashtags, mentions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillSessions mirrors the JSON migration 11, then drops the
+// refresh tokens it replaces
+func backfillSessions(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT user_id, token, expires_at FROM refresh_tokens ORDER BY user_id")
+	if err != nil {
+		return err
+	}
+	sessions := []entities.Session{}
+	for rows.Next() {
+		var s entities.Session
+		var token string
+		if err := rows.Scan(&s.UserId, &token, &s.ExpiresAt); err != nil {
+			rows.Close()
+			return err
+		}
+		s.TokenHash = unkeyedTokenHash(token)
+		sessions = append(sessions, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, s := range sessions {
+		_, err := tx.Exec(
+			"INSERT INTO sessions (user_id, token_hash, user_agent, ip, created_at, last_used_at, expires_at) VALUES (?, ?, '', '', ?, ?, ?)",
+			s.UserId, s.TokenHash, now, now, s.ExpiresAt,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("DROP TABLE refresh_tokens")
+	return err
+}
+
+// backfillSessionExpirations rewrites the expirations of the sessions
+// saved in local time in UTC
+func backfillSessionExpirations(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT id, expires_at FROM sessions")
+	if err != nil {
+		return err
+	}
+	expirations := map[int]time.Time{}
+	for rows.Next() {
+		var id int
+		var expiresAt time.Time
+		if err := rows.Scan(&id, &expiresAt); err != nil {
+			rows.Close()
+			return err
+		}
+		expirations[id] = expiresAt
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for id, expiresAt := range expirations {
+		if _, err := tx.Exec("UPDATE sessions SET expires_at = ? WHERE id = ?", expiresAt.UTC(), id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
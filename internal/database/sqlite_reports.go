@@ -0,0 +1,219 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+const reportColumns = "id, reporter_id, chirp_id, user_id, reason, status, created_at, resolved_at"
+
+func scanReport(row rowScanner) (*entities.Report, error) {
+	var r entities.Report
+	var reporterId, chirpId sql.NullInt64
+	var resolvedAt sql.NullTime
+	if err := row.Scan(&r.Id, &reporterId, &chirpId, &r.UserId, &r.Reason, &r.Status, &r.CreatedAt, &resolvedAt); err != nil {
+		return nil, err
+	}
+	r.ReporterId = nullableInt(reporterId)
+	r.ChirpId = nullableInt(chirpId)
+	if resolvedAt.Valid {
+		r.ResolvedAt = &resolvedAt.Time
+	}
+	return &r, nil
+}
+
+// queryer and rowQueryer are implemented by both *sql.DB and *sql.Tx
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+type rowQueryer interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func queryReports(q queryer, query string, args ...any) ([]entities.Report, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	reports := []entities.Report{}
+	for rows.Next() {
+		r, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, *r)
+	}
+	return reports, rows.Err()
+}
+
+func insertReport(tx *sql.Tx, report entities.Report) (int, error) {
+	res, err := tx.Exec(
+		"INSERT INTO reports (reporter_id, chirp_id, user_id, reason, status, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		report.ReporterId, report.ChirpId, report.UserId, report.Reason, report.Status, report.CreatedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// saveFlagReport mirrors fileFlagReport
+func saveFlagReport(tx *sql.Tx, chirp entities.Chirp) error {
+	if len(chirp.Flags) == 0 {
+		return nil
+	}
+	var queued bool
+	err := tx.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM reports WHERE chirp_id = ? AND reporter_id IS NULL AND status = ?)",
+		chirp.Id, entities.ReportOpen,
+	).Scan(&queued)
+	if err != nil || queued {
+		return err
+	}
+	_, err = insertReport(tx, entities.Report{
+		ChirpId:   &chirp.Id,
+		UserId:    chirp.UserId,
+		Reason:    flagReason(chirp.Flags),
+		Status:    entities.ReportOpen,
+		CreatedAt: time.Now().UTC(),
+	})
+	return err
+}
+
+// CreateReport files an open report about a chirp the reporter may read, when ChirpId
+// is set, or else about the user UserId. For chirps, UserId is set to
+// their author.
+func (db *SQLiteDB) CreateReport(report entities.Report) (*entities.Report, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if report.ChirpId != nil {
+		filter, args := readableChirp(report.ReporterId)
+		err := tx.QueryRow("SELECT author_id FROM chirps WHERE id = ? AND "+filter, append([]any{*report.ChirpId}, args...)...).Scan(&report.UserId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrChirpNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		err := tx.QueryRow("SELECT id FROM users WHERE id = ?", report.UserId).Scan(&report.UserId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	open, err := queryReports(tx, "SELECT "+reportColumns+" FROM reports WHERE user_id = ? AND status = ?", report.UserId, entities.ReportOpen)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range open {
+		if isDuplicateReport(r, report) {
+			return nil, ErrDuplicateReport
+		}
+	}
+	report.Status = entities.ReportOpen
+	report.CreatedAt = time.Now().UTC()
+	report.ResolvedAt = nil
+	if report.Id, err = insertReport(tx, report); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// GetReports returns the reports with the given status, or all of them
+// when status is empty, oldest first
+func (db *SQLiteDB) GetReports(status entities.ReportStatus) ([]entities.Report, error) {
+	if status == "" {
+		return queryReports(db.conn, "SELECT "+reportColumns+" FROM reports ORDER BY id")
+	}
+	return queryReports(db.conn, "SELECT "+reportColumns+" FROM reports WHERE status = ? ORDER BY id", status)
+}
+
+// ResolveReport closes an open report with the given status, taking the
+// matching action: hiding the reported chirp or suspending the reported
+// user closes their other open reports along with it
+func (db *SQLiteDB) ResolveReport(id int, status entities.ReportStatus) (*entities.Report, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	report, err := scanReport(tx.QueryRow("SELECT "+reportColumns+" FROM reports WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrReportNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if report.Status != entities.ReportOpen {
+		return nil, ErrReportResolved
+	}
+	now := time.Now().UTC()
+	// the open reports closed along with this one
+	where, target := "id = ?", id
+	switch status {
+	case entities.ReportDismissed:
+	case entities.ReportChirpHidden:
+		if report.ChirpId == nil {
+			return nil, ErrNoReportedChirp
+		}
+		if err := updateOne(tx, ErrChirpNotFound, "UPDATE chirps SET hidden = 1 WHERE id = ?", *report.ChirpId); err != nil {
+			return nil, err
+		}
+		where, target = "chirp_id = ?", *report.ChirpId
+	case entities.ReportUserSuspended:
+		if err := updateOne(tx, ErrUserNotFound, "UPDATE users SET suspended = 1 WHERE id = ?", report.UserId); err != nil {
+			return nil, err
+		}
+		if _, err := endSessions(tx, "user_id = ?", report.UserId); err != nil {
+			return nil, err
+		}
+		where, target = "user_id = ?", report.UserId
+	default:
+		return nil, ErrInvalidResolution
+	}
+	_, err = tx.Exec(
+		"UPDATE reports SET status = ?, resolved_at = ? WHERE "+where+" AND status = ?",
+		status, now, target, entities.ReportOpen,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	report.Status = status
+	report.ResolvedAt = &now
+	return report, nil
+}
+
+// updateOne runs an update expected to change a single row,
+// failing with notFound when it changes none
+func updateOne(tx *sql.Tx, notFound error, query string, args ...any) error {
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+const sessionColumns = "id, user_id, token_hash, user_agent, ip, created_at, last_used_at, expires_at"
+
+func scanSession(row rowScanner) (*entities.Session, error) {
+	var s entities.Session
+	if err := row.Scan(&s.Id, &s.UserId, &s.TokenHash, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// CreateSession saves a new session, assigning its id and timestamps.
+// The expired sessions of the same user are dropped.
+func (db *SQLiteDB) CreateSession(session entities.Session) (*entities.Session, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	session.ExpiresAt = session.ExpiresAt.UTC()
+	if _, err := tx.Exec("DELETE FROM sessions WHERE user_id = ? AND expires_at < ?", session.UserId, now); err != nil {
+		return nil, err
+	}
+	res, err := tx.Exec(
+		"INSERT INTO sessions (user_id, token_hash, user_agent, ip, created_at, last_used_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		session.UserId, session.TokenHash, session.UserAgent, session.IP, now, now, session.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range session.AccessTokens {
+		if err := insertAccessToken(tx, int(id), t); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	session.Id = int(id)
+	session.CreatedAt = now
+	session.LastUsedAt = now
+	return &session, nil
+}
+
+// insertAccessToken adds an access token to the session sessionId
+func insertAccessToken(tx *sql.Tx, sessionId int, token entities.AccessToken) error {
+	_, err := tx.Exec(
+		"INSERT INTO access_tokens (id, session_id, expires_at) VALUES (?, ?, ?)",
+		token.Id, sessionId, token.ExpiresAt,
+	)
+	return err
+}
+
+// RotateSession replaces the refresh token with hash tokenHash by the one
+// with hash newTokenHash, retiring the former, and adds accessToken to the
+// session, unless it expired. A retired token presented again revokes the
+// whole session, which is recorded as a security event along with the
+// client ip and user agent, and fails with ErrTokenReused.
+func (db *SQLiteDB) RotateSession(tokenHash, newTokenHash string, accessToken entities.AccessToken, ip, userAgent string) (*entities.Session, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	session, err := scanSession(tx.QueryRow("SELECT "+sessionColumns+" FROM sessions WHERE token_hash = ?", tokenHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, revokeReusedSession(tx, tokenHash, ip, userAgent, now)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if session.ExpiresAt.Before(now) {
+		return nil, ErrSessionExpired
+	}
+	if _, err := tx.Exec("INSERT INTO retired_tokens (token_hash, session_id) VALUES (?, ?)", tokenHash, session.Id); err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(
+		"DELETE FROM retired_tokens WHERE session_id = ? AND rowid NOT IN (SELECT rowid FROM retired_tokens WHERE session_id = ? ORDER BY rowid DESC LIMIT ?)",
+		session.Id, session.Id, maxRetiredTokens,
+	)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec("UPDATE sessions SET token_hash = ?, last_used_at = ? WHERE id = ?", newTokenHash, now, session.Id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM access_tokens WHERE session_id = ? AND expires_at < ?", session.Id, now); err != nil {
+		return nil, err
+	}
+	if err := insertAccessToken(tx, session.Id, accessToken); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	session.TokenHash = newTokenHash
+	session.LastUsedAt = now
+	return session, nil
+}
+
+// revokeReusedSession deletes the session that retired the refresh token
+// with hash tokenHash and records the reuse, committing tx. It fails with
+// ErrTokenReused, or ErrSessionNotFound when no session retired the token.
+func revokeReusedSession(tx *sql.Tx, tokenHash, ip, userAgent string, now time.Time) error {
+	var sessionId, userId int
+	err := tx.QueryRow(
+		"SELECT sessions.id, sessions.user_id FROM retired_tokens JOIN sessions ON sessions.id = retired_tokens.session_id WHERE retired_tokens.token_hash = ?",
+		tokenHash,
+	).Scan(&sessionId, &userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := endSessions(tx, "id = ?", sessionId); err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO security_events (user_id, kind, session_id, ip, user_agent, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		userId, entities.SecurityEventTokenReuse, sessionId, ip, userAgent, now,
+	)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return ErrTokenReused
+}
+
+// GetSessions returns the sessions of userId that did not expire, oldest first
+func (db *SQLiteDB) GetSessions(userId int) ([]entities.Session, error) {
+	rows, err := db.conn.Query(
+		"SELECT "+sessionColumns+" FROM sessions WHERE user_id = ? AND expires_at >= ? ORDER BY id",
+		userId, time.Now().UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions := []entities.Session{}
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *s)
+	}
+	return sessions, rows.Err()
+}
+
+// DeleteSession ends the session id of userId
+func (db *SQLiteDB) DeleteSession(userId, id int) error {
+	return db.deleteSessions(true, "id = ? AND user_id = ?", id, userId)
+}
+
+// DeleteSessionByToken ends the session whose refresh token has the given hash
+func (db *SQLiteDB) DeleteSessionByToken(tokenHash string) error {
+	return db.deleteSessions(true, "token_hash = ?", tokenHash)
+}
+
+// DeleteUserSessions is an idempotent operation that ends every session of userId
+func (db *SQLiteDB) DeleteUserSessions(userId int) error {
+	return db.deleteSessions(false, "user_id = ?", userId)
+}
+
+// deleteSessions ends the sessions matching where, failing when it
+// matches none if they are required
+func (db *SQLiteDB) deleteSessions(required bool, where string, args ...any) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	n, err := endSessions(tx, where, args...)
+	if err != nil {
+		return err
+	}
+	if n == 0 && required {
+		return ErrSessionNotFound
+	}
+	return tx.Commit()
+}
+
+// endSessions deletes the sessions matching where and denylists their
+// access tokens that did not expire yet, dropping the denylisted tokens
+// that expired. It returns the number of sessions deleted.
+func endSessions(tx *sql.Tx, where string, args ...any) (int64, error) {
+	now := time.Now().UTC()
+	if _, err := tx.Exec("DELETE FROM revoked_tokens WHERE expires_at < ?", now); err != nil {
+		return 0, err
+	}
+	_, err := tx.Exec(
+		"INSERT OR IGNORE INTO revoked_tokens (id, expires_at) SELECT id, expires_at FROM access_tokens WHERE expires_at >= ? AND session_id IN (SELECT id FROM sessions WHERE "+where+")",
+		append([]any{now}, args...)...,
+	)
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec("DELETE FROM sessions WHERE "+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// IsTokenRevoked tells whether the access token with the given jti was revoked
+func (db *SQLiteDB) IsTokenRevoked(id string) (bool, error) {
+	var revoked bool
+	err := db.conn.QueryRow("SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE id = ?)", id).Scan(&revoked)
+	return revoked, err
+}
+
+// GetSecurityEvents returns the security events of userId, newest first
+func (db *SQLiteDB) GetSecurityEvents(userId int) ([]entities.SecurityEvent, error) {
+	rows, err := db.conn.Query(
+		"SELECT id, user_id, kind, session_id, ip, user_agent, created_at FROM security_events WHERE user_id = ? ORDER BY id DESC",
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []entities.SecurityEvent{}
+	for rows.Next() {
+		var e entities.SecurityEvent
+		if err := rows.Scan(&e.Id, &e.UserId, &e.Kind, &e.SessionId, &e.IP, &e.UserAgent, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,153 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+func newTestSQLiteDB(t *testing.T) *SQLiteDB {
+	t.Helper()
+	db, err := NewSQLiteDB(filepath.Join(t.TempDir(), "database.sqlite"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// runConcurrently calls fn with 0 to concurrentWriters-1 from as many
+// goroutines and fails on the first error any returned
+func runConcurrently(t *testing.T, fn func(i int) error) {
+	t.Helper()
+	errs := make([]error, concurrentWriters)
+	var wg sync.WaitGroup
+	for i := range concurrentWriters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn(i)
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+	}
+}
+
+func TestSQLiteConcurrentSignups(t *testing.T) {
+	db := newTestSQLiteDB(t)
+	runConcurrently(t, func(i int) error {
+		// the same local part makes every signup derive a new handle
+		_, err := db.CreateUser(fmt.Sprintf("user@example%d.com", i), "hash", false)
+		return err
+	})
+	users, err := db.GetUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handles := map[string]bool{}
+	for _, u := range users {
+		if handles[u.Handle] {
+			t.Fatalf("handle %q assigned twice", u.Handle)
+		}
+		handles[u.Handle] = true
+	}
+	if len(users) != concurrentWriters {
+		t.Fatalf("got %d users, want %d", len(users), concurrentWriters)
+	}
+}
+
+func TestSQLiteConcurrentReplies(t *testing.T) {
+	db := newTestSQLiteDB(t)
+	user, err := db.CreateUser("a@example.com", "hash", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := db.CreateChirp(entities.Chirp{UserId: user.Id, Body: "root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runConcurrently(t, func(i int) error {
+		_, err := db.CreateChirp(entities.Chirp{UserId: user.Id, Body: fmt.Sprintf("reply %d", i), InReplyTo: &root.Id})
+		return err
+	})
+	thread, err := db.GetThread(root.Id, &user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thread) != concurrentWriters+1 {
+		t.Fatalf("got %d chirps in the thread, want %d", len(thread), concurrentWriters+1)
+	}
+	for _, c := range thread {
+		if c.Id != root.Id && (c.RootId == nil || *c.RootId != root.Id) {
+			t.Fatalf("reply %d is not in the thread of %d", c.Id, root.Id)
+		}
+	}
+}
+
+func TestSQLiteSessionExpirationInLocalTime(t *testing.T) {
+	db := newTestSQLiteDB(t)
+	user, err := db.CreateUser("a@example.com", "hash", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ahead of UTC, a local time string compares after the UTC ones
+	eet := time.FixedZone("EET", 2*60*60)
+	_, err = db.CreateSession(entities.Session{
+		UserId:    user.Id,
+		TokenHash: "hash",
+		ExpiresAt: time.Now().Add(-10 * time.Minute).In(eet),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := db.GetSessions(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 0 {
+		t.Fatalf("got %d sessions, want the expired one left out", len(sessions))
+	}
+}
+
+func TestSQLiteMigrationRewritesSessionExpirations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.sqlite")
+	db, err := NewSQLiteDB(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := db.CreateUser("a@example.com", "hash", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eet := time.FixedZone("EET", 2*60*60)
+	expired := time.Now().Add(-10 * time.Minute).In(eet)
+	// a session saved in local time before migration 17
+	_, err = db.conn.Exec(
+		"INSERT INTO sessions (user_id, token_hash, user_agent, ip, created_at, last_used_at, expires_at) VALUES (?, 'hash', '', '', ?, ?, ?)",
+		user.Id, expired, expired, expired,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.conn.Exec("PRAGMA user_version = 16"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = NewSQLiteDB(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if sessions, err := db.GetSessions(user.Id); err != nil || len(sessions) != 0 {
+		t.Fatalf("got %v and %v, want the expired session left out", sessions, err)
+	}
+}
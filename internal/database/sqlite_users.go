@@ -0,0 +1,115 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+const userColumns = "id, email, password, is_chirpy_red, handle, suspended"
+
+func scanUser(row rowScanner) (*entities.User, error) {
+	var u entities.User
+	if err := row.Scan(&u.Id, &u.Email, &u.Password, &u.IsChirpyRed, &u.Handle, &u.Suspended); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (db *SQLiteDB) queryUsers(query string, args ...any) ([]entities.User, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := []entities.User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *u)
+	}
+	return users, rows.Err()
+}
+
+// CreateUser creates a new user and saves it to disk
+func (db *SQLiteDB) CreateUser(email, password string, isChirpyRed bool) (*entities.User, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	handle := uniqueHandle(entities.HandleFromEmail(email), func(h string) bool {
+		_, taken := userIdByHandle(tx)(h)
+		return taken
+	})
+	res, err := tx.Exec(
+		"INSERT INTO users (email, password, is_chirpy_red, handle) VALUES (?, ?, ?, ?)",
+		email, password, isChirpyRed, handle,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrDuplicateUser
+		}
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &entities.User{Id: int(id), Email: email, Password: password, IsChirpyRed: isChirpyRed, Handle: handle}, nil
+}
+
+// GetUsers returns all users in the database
+func (db *SQLiteDB) GetUsers() ([]entities.User, error) {
+	return db.queryUsers("SELECT " + userColumns + " FROM users")
+}
+
+// GetUserByID returns the user with the given id
+func (db *SQLiteDB) GetUserByID(id int) (*entities.User, error) {
+	return db.getUser("id = ?", id)
+}
+
+// GetUserByEmail returns the user registered with the given email
+func (db *SQLiteDB) GetUserByEmail(email string) (*entities.User, error) {
+	return db.getUser("email = ?", email)
+}
+
+func (db *SQLiteDB) getUser(where string, args ...any) (*entities.User, error) {
+	u, err := scanUser(db.conn.QueryRow("SELECT "+userColumns+" FROM users WHERE "+where, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	return u, err
+}
+
+// UpdateUser updates a user attributes and returns it
+func (db *SQLiteDB) UpdateUser(user *entities.User) (*entities.User, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if otherId, taken := userIdByHandle(tx)(user.Handle); taken && otherId != user.Id {
+		return nil, ErrDuplicateHandle
+	}
+	_, err = tx.Exec(
+		"UPDATE users SET email = ?, password = ?, is_chirpy_red = ?, handle = ? WHERE id = ?",
+		user.Email, user.Password, user.IsChirpyRed, user.Handle, user.Id,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrDuplicateUser
+		}
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
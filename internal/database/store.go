@@ -0,0 +1,64 @@
+package database
+
+import (
+	"time"
+
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+// Store is the persistence layer the API depends on.
+// DB implements it on top of a JSON file or plain memory,
+// SQLiteDB on top of a SQLite database.
+type Store interface {
+	CreateChirp(chirp entities.Chirp) (*entities.Chirp, error)
+	GetChirps(userId *int) ([]entities.Chirp, error)
+	ListChirps(q ChirpQuery) ([]entities.Chirp, bool, error)
+	SearchChirps(q SearchQuery) ([]entities.Chirp, error)
+	GetChirpByID(id int) (*entities.Chirp, error)
+	GetVisibleChirp(id int, viewerId *int) (*entities.Chirp, error)
+	UpdateChirp(id int, body string, flags []string) (*entities.Chirp, error)
+	GetChirpHistory(id int) ([]entities.ChirpRevision, error)
+	GetThread(rootId int, viewerId *int) ([]entities.Chirp, error)
+	DeleteChirp(id int) error
+	TrendingHashtags(since time.Time, limit int) ([]entities.HashtagCount, error)
+
+	CreateUser(email, password string, isChirpyRed bool) (*entities.User, error)
+	GetUsers() ([]entities.User, error)
+	GetUserByID(id int) (*entities.User, error)
+	GetUserByEmail(email string) (*entities.User, error)
+	UpdateUser(user *entities.User) (*entities.User, error)
+
+	Follow(followerId, followeeId int) error
+	Unfollow(followerId, followeeId int) error
+	GetFollowers(userId int) ([]entities.User, error)
+	GetFollowing(userId int) ([]entities.User, error)
+	GetTimeline(userId int) ([]entities.TimelineItem, error)
+
+	Block(blockerId, userId int) error
+	Unblock(blockerId, userId int) error
+	Mute(muterId, userId int) error
+	Unmute(muterId, userId int) error
+	GetBlocked(userId int) ([]entities.User, error)
+	GetMuted(userId int) ([]entities.User, error)
+
+	Like(userId, chirpId int) error
+	Unlike(userId, chirpId int) error
+	Rechirp(userId, chirpId int) error
+	Unrechirp(userId, chirpId int) error
+	GetChirpStats(chirpIds []int, viewerId *int) (map[int]entities.ChirpStats, error)
+
+	CreateReport(report entities.Report) (*entities.Report, error)
+	GetReports(status entities.ReportStatus) ([]entities.Report, error)
+	ResolveReport(id int, status entities.ReportStatus) (*entities.Report, error)
+
+	CreateSession(session entities.Session) (*entities.Session, error)
+	RotateSession(tokenHash, newTokenHash string, accessToken entities.AccessToken, ip, userAgent string) (*entities.Session, error)
+	GetSessions(userId int) ([]entities.Session, error)
+	DeleteSession(userId, id int) error
+	DeleteSessionByToken(tokenHash string) error
+	DeleteUserSessions(userId int) error
+	GetSecurityEvents(userId int) ([]entities.SecurityEvent, error)
+	IsTokenRevoked(id string) (bool, error)
+}
+
+var _ Store = (*DB)(nil)
@@ -0,0 +1,616 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sp3dr4/chirpy/internal/entities"
+	"github.com/sp3dr4/chirpy/internal/search"
+)
+
+var errReadOnlyTx = errors.New("cannot write in a read-only transaction")
+
+// Tx gives access to the database content for the duration of a
+// View or Update call. It must not be used after the call returns.
+type Tx struct {
+	db       *DB
+	writable bool
+	entries  []journalEntry
+	undo     []journalEntry
+}
+
+// View runs fn in a read-only transaction
+func (db *DB) View(fn func(tx *Tx) error) error {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	return fn(&Tx{db: db})
+}
+
+// Update runs fn in a read-write transaction. The write lock is held for
+// the whole read-modify-write, so concurrent updates never overwrite each
+// other. Changes are kept only if fn returns nil and they are persisted.
+func (db *DB) Update(fn func(tx *Tx) error) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	tx := &Tx{db: db, writable: true}
+	err := fn(tx)
+	if err == nil && len(tx.entries) > 0 {
+		err = db.persist(tx.entries)
+	}
+	if err != nil {
+		tx.rollback()
+		return err
+	}
+	return nil
+}
+
+func (tx *Tx) rollback() {
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		if _, err := tx.db.applyEntry(tx.undo[i]); err != nil {
+			panic(fmt.Sprintf("rollback failed: %v", err))
+		}
+	}
+}
+
+func (tx *Tx) apply(e journalEntry) error {
+	if !tx.writable {
+		return errReadOnlyTx
+	}
+	undo, err := tx.db.applyEntry(e)
+	if err != nil {
+		return err
+	}
+	tx.entries = append(tx.entries, e)
+	tx.undo = append(tx.undo, undo)
+	return nil
+}
+
+// NextChirpId reserves the id for a new chirp
+func (tx *Tx) NextChirpId() int {
+	tx.db.chirpLastId += 1
+	return tx.db.chirpLastId
+}
+
+// NextUserId reserves the id for a new user
+func (tx *Tx) NextUserId() int {
+	tx.db.userLastId += 1
+	return tx.db.userLastId
+}
+
+func (tx *Tx) Chirp(id int) (entities.Chirp, bool) {
+	chirp, ok := tx.db.data.Chirps[id]
+	return chirp, ok
+}
+
+// Listed tells whether chirp shows up in listings, that is
+// it is not hidden and its author is not suspended
+func (tx *Tx) Listed(chirp entities.Chirp) bool {
+	author, found := tx.User(chirp.UserId)
+	return !chirp.Hidden && !(found && author.Suspended)
+}
+
+func (tx *Tx) Chirps() []entities.Chirp {
+	chirps := make([]entities.Chirp, 0, len(tx.db.data.Chirps))
+	for _, c := range tx.db.data.Chirps {
+		chirps = append(chirps, c)
+	}
+	return chirps
+}
+
+func (tx *Tx) ChirpsByAuthor(userId int) []entities.Chirp {
+	ids := tx.db.idx.chirpsByAuthor[userId]
+	chirps := make([]entities.Chirp, 0, len(ids))
+	for _, id := range ids {
+		chirps = append(chirps, tx.db.data.Chirps[id])
+	}
+	return chirps
+}
+
+// ChirpsPage returns up to limit chirps readable by q.ViewerId, optionally
+// by a single author, with a single hashtag or in the viewer's inbox,
+// following afterId in ascending or descending id order. A zero afterId
+// starts from the first chirp in that order.
+// It also reports whether more chirps follow the page.
+func (tx *Tx) ChirpsPage(q ChirpQuery) ([]entities.Chirp, bool) {
+	desc, afterId, limit := q.Desc, q.AfterId, q.Limit
+	ids := tx.db.idx.chirpIds
+	if q.Inbox {
+		ids = nil
+		if q.ViewerId != nil {
+			ids = tx.db.idx.directByRecipient[*q.ViewerId]
+		}
+	} else if q.AuthorId != nil {
+		ids = tx.db.idx.chirpsByAuthor[*q.AuthorId]
+	} else if q.Hashtag != "" {
+		ids = tx.db.idx.chirpsByHashtag[q.Hashtag]
+	}
+	// ids[start:] are the chirps after afterId when ascending,
+	// ids[:start] the ones before it when descending
+	start := 0
+	if afterId != 0 {
+		var found bool
+		start, found = slices.BinarySearch(ids, afterId)
+		if found && !desc {
+			start++
+		}
+	} else if desc {
+		start = len(ids)
+	}
+
+	// one extra chirp tells whether there is a next page,
+	// chirps the viewer may not read are skipped
+	filter := tx.ChirpFilter(q.ViewerId)
+	chirps := make([]entities.Chirp, 0, limit+1)
+	add := func(id int) {
+		if chirp := tx.db.data.Chirps[id]; filter.Allows(chirp) {
+			chirps = append(chirps, chirp)
+		}
+	}
+	if desc {
+		for i := start - 1; i >= 0 && len(chirps) <= limit; i-- {
+			add(ids[i])
+		}
+	} else {
+		for i := start; i < len(ids) && len(chirps) <= limit; i++ {
+			add(ids[i])
+		}
+	}
+	if len(chirps) > limit {
+		return chirps[:limit], true
+	}
+	return chirps, false
+}
+
+// ThreadReplies returns every chirp of the conversation started by rootId
+// that viewerId may read, except the root itself
+func (tx *Tx) ThreadReplies(rootId int, viewerId *int) []entities.Chirp {
+	filter := tx.ChirpFilter(viewerId)
+	ids := tx.db.idx.repliesByRoot[rootId]
+	chirps := make([]entities.Chirp, 0, len(ids))
+	for id := range ids {
+		if chirp := tx.db.data.Chirps[id]; filter.Allows(chirp) {
+			chirps = append(chirps, chirp)
+		}
+	}
+	return chirps
+}
+
+// SearchChirps returns up to limit chirps readable by viewerId matching q,
+// optionally by a single author, most relevant first
+func (tx *Tx) SearchChirps(q search.Query, authorId, viewerId *int, limit int) []entities.Chirp {
+	filter := tx.ChirpFilter(viewerId)
+	chirps := make([]entities.Chirp, 0, limit)
+	for _, r := range tx.db.idx.chirpText.Search(q) {
+		if len(chirps) == limit {
+			break
+		}
+		chirp := tx.db.data.Chirps[r.Id]
+		if (authorId != nil && chirp.UserId != *authorId) || !filter.Allows(chirp) {
+			continue
+		}
+		chirps = append(chirps, chirp)
+	}
+	return chirps
+}
+
+// TrendingHashtags counts the hashtags of the public chirps created
+// since the given time and returns the limit most used ones
+func (tx *Tx) TrendingHashtags(since time.Time, limit int) []entities.HashtagCount {
+	filter := tx.ChirpFilter(nil)
+	counts := map[string]int{}
+	// ids are assigned in creation order, the scan stops at the first older chirp
+	ids := tx.db.idx.chirpIds
+	for i := len(ids) - 1; i >= 0; i-- {
+		chirp := tx.db.data.Chirps[ids[i]]
+		if chirp.CreatedAt.Before(since) {
+			break
+		}
+		if !filter.Allows(chirp) {
+			continue
+		}
+		for _, tag := range chirp.Hashtags {
+			counts[tag] += 1
+		}
+	}
+	trending := make([]entities.HashtagCount, 0, len(counts))
+	for tag, count := range counts {
+		trending = append(trending, entities.HashtagCount{Tag: tag, Count: count})
+	}
+	slices.SortFunc(trending, func(a, b entities.HashtagCount) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		return strings.Compare(a.Tag, b.Tag)
+	})
+	return trending[:min(len(trending), limit)]
+}
+
+func (tx *Tx) PutChirp(chirp entities.Chirp) error {
+	return tx.apply(journalEntry{Op: opPutChirp, Chirp: &chirp})
+}
+
+func (tx *Tx) DeleteChirp(id int) error {
+	return tx.apply(journalEntry{Op: opDeleteChirp, Id: id})
+}
+
+// ChirpRevisions returns the previous bodies of a chirp, oldest first
+func (tx *Tx) ChirpRevisions(chirpId int) []entities.ChirpRevision {
+	return slices.Clone(tx.db.data.ChirpRevisions[chirpId])
+}
+
+func (tx *Tx) PutChirpRevisions(chirpId int, revisions []entities.ChirpRevision) error {
+	return tx.apply(journalEntry{Op: opPutRevisions, Id: chirpId, Revisions: revisions})
+}
+
+func (tx *Tx) DeleteChirpRevisions(chirpId int) error {
+	return tx.apply(journalEntry{Op: opDeleteRevisions, Id: chirpId})
+}
+
+func (tx *Tx) User(id int) (entities.User, bool) {
+	user, ok := tx.db.data.Users[id]
+	return user, ok
+}
+
+func (tx *Tx) Users() []entities.User {
+	users := make([]entities.User, 0, len(tx.db.data.Users))
+	for _, u := range tx.db.data.Users {
+		users = append(users, u)
+	}
+	return users
+}
+
+func (tx *Tx) UserByEmail(email string) (entities.User, bool) {
+	id, ok := tx.db.idx.userByEmail[email]
+	if !ok {
+		return entities.User{}, false
+	}
+	return tx.User(id)
+}
+
+func (tx *Tx) UserByHandle(handle string) (entities.User, bool) {
+	id, ok := tx.userIdByHandle(handle)
+	if !ok {
+		return entities.User{}, false
+	}
+	return tx.User(id)
+}
+
+func (tx *Tx) userIdByHandle(handle string) (int, bool) {
+	id, ok := tx.db.idx.userByHandle[handle]
+	return id, ok
+}
+
+func (tx *Tx) PutUser(user entities.User) error {
+	return tx.apply(journalEntry{Op: opPutUser, User: &user})
+}
+
+func (tx *Tx) NextSessionId() int {
+	tx.db.sessionLastId += 1
+	return tx.db.sessionLastId
+}
+
+func (tx *Tx) Session(id int) (entities.Session, bool) {
+	session, ok := tx.db.data.Sessions[id]
+	return session, ok
+}
+
+// SessionByToken returns the session whose refresh token has the given hash
+func (tx *Tx) SessionByToken(tokenHash string) (entities.Session, bool) {
+	id, ok := tx.db.idx.sessionByToken[tokenHash]
+	if !ok {
+		return entities.Session{}, false
+	}
+	return tx.db.data.Sessions[id], true
+}
+
+// SessionByRetiredToken returns the session that rotated out the refresh
+// token with the given hash
+func (tx *Tx) SessionByRetiredToken(tokenHash string) (entities.Session, bool) {
+	id, ok := tx.db.idx.sessionByRetiredToken[tokenHash]
+	if !ok {
+		return entities.Session{}, false
+	}
+	return tx.db.data.Sessions[id], true
+}
+
+// UserSessions returns the sessions of userId, oldest first
+func (tx *Tx) UserSessions(userId int) []entities.Session {
+	sessions := make([]entities.Session, 0, len(tx.db.idx.sessionsByUser[userId]))
+	for id := range tx.db.idx.sessionsByUser[userId] {
+		sessions = append(sessions, tx.db.data.Sessions[id])
+	}
+	slices.SortFunc(sessions, func(a, b entities.Session) int { return a.Id - b.Id })
+	return sessions
+}
+
+func (tx *Tx) PutSession(session entities.Session) error {
+	return tx.apply(journalEntry{Op: opPutSession, Session: &session})
+}
+
+func (tx *Tx) DeleteSession(id int) error {
+	return tx.apply(journalEntry{Op: opDeleteSession, Id: id})
+}
+
+func (tx *Tx) NextEventId() int {
+	tx.db.eventLastId += 1
+	return tx.db.eventLastId
+}
+
+// UserEvents returns the security events of userId, newest first
+func (tx *Tx) UserEvents(userId int) []entities.SecurityEvent {
+	events := make([]entities.SecurityEvent, 0, len(tx.db.idx.eventsByUser[userId]))
+	for id := range tx.db.idx.eventsByUser[userId] {
+		events = append(events, tx.db.data.SecurityEvents[id])
+	}
+	slices.SortFunc(events, func(a, b entities.SecurityEvent) int { return b.Id - a.Id })
+	return events
+}
+
+func (tx *Tx) PutEvent(event entities.SecurityEvent) error {
+	return tx.apply(journalEntry{Op: opPutEvent, Event: &event})
+}
+
+// RevokedTokens returns the denylisted access tokens
+func (tx *Tx) RevokedTokens() []entities.AccessToken {
+	tokens := make([]entities.AccessToken, 0, len(tx.db.data.RevokedTokens))
+	for _, t := range tx.db.data.RevokedTokens {
+		tokens = append(tokens, t)
+	}
+	return tokens
+}
+
+// IsRevoked tells whether the access token with the given jti is denylisted
+func (tx *Tx) IsRevoked(id string) bool {
+	_, ok := tx.db.data.RevokedTokens[id]
+	return ok
+}
+
+func (tx *Tx) PutRevokedToken(token entities.AccessToken) error {
+	return tx.apply(journalEntry{Op: opPutRevoked, Revoked: &token})
+}
+
+func (tx *Tx) DeleteRevokedToken(token entities.AccessToken) error {
+	return tx.apply(journalEntry{Op: opDeleteRevoked, Revoked: &token})
+}
+
+// Following returns the ids of the users followed by userId
+func (tx *Tx) Following(userId int) []int {
+	return slices.Clone(tx.db.data.Follows[userId])
+}
+
+// Followers returns the ids of the users following userId
+func (tx *Tx) Followers(userId int) []int {
+	ids := make([]int, 0, len(tx.db.idx.followers[userId]))
+	for id := range tx.db.idx.followers[userId] {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// PutFollowing replaces the users followed by userId
+func (tx *Tx) PutFollowing(userId int, ids []int) error {
+	if len(ids) == 0 {
+		if _, ok := tx.db.data.Follows[userId]; !ok {
+			return nil
+		}
+		return tx.apply(journalEntry{Op: opDeleteFollows, Id: userId})
+	}
+	return tx.apply(journalEntry{Op: opPutFollows, Id: userId, Ids: ids})
+}
+
+// Blocked returns the ids of the users blocked by userId
+func (tx *Tx) Blocked(userId int) []int {
+	return slices.Clone(tx.db.data.Blocks[userId])
+}
+
+// Blocks tells whether blockerId blocks userId
+func (tx *Tx) Blocks(blockerId, userId int) bool {
+	_, found := tx.db.idx.blockedBy[userId][blockerId]
+	return found
+}
+
+// PutBlocked replaces the users blocked by userId
+func (tx *Tx) PutBlocked(userId int, ids []int) error {
+	if len(ids) == 0 {
+		if _, ok := tx.db.data.Blocks[userId]; !ok {
+			return nil
+		}
+		return tx.apply(journalEntry{Op: opDeleteBlocks, Id: userId})
+	}
+	return tx.apply(journalEntry{Op: opPutBlocks, Id: userId, Ids: ids})
+}
+
+// Muted returns the ids of the users muted by userId
+func (tx *Tx) Muted(userId int) []int {
+	return slices.Clone(tx.db.data.Mutes[userId])
+}
+
+// PutMuted replaces the users muted by userId
+func (tx *Tx) PutMuted(userId int, ids []int) error {
+	if len(ids) == 0 {
+		if _, ok := tx.db.data.Mutes[userId]; !ok {
+			return nil
+		}
+		return tx.apply(journalEntry{Op: opDeleteMutes, Id: userId})
+	}
+	return tx.apply(journalEntry{Op: opPutMutes, Id: userId, Ids: ids})
+}
+
+// ExcludedAuthors returns the users whose chirps viewerId does not see:
+// the ones they block or mute and the ones blocking them.
+// It is empty for anonymous viewers.
+func (tx *Tx) ExcludedAuthors(viewerId *int) map[int]struct{} {
+	excluded := map[int]struct{}{}
+	if viewerId == nil {
+		return excluded
+	}
+	for _, id := range tx.db.data.Blocks[*viewerId] {
+		excluded[id] = struct{}{}
+	}
+	for _, id := range tx.db.data.Mutes[*viewerId] {
+		excluded[id] = struct{}{}
+	}
+	for id := range tx.db.idx.blockedBy[*viewerId] {
+		excluded[id] = struct{}{}
+	}
+	return excluded
+}
+
+// Readable tells whether viewerId may read chirp regardless of blocks
+// and mutes: it is listed and its visibility lets the viewer read it.
+// Anonymous viewers only read public chirps.
+func (tx *Tx) Readable(chirp entities.Chirp, viewerId *int) bool {
+	if !tx.Listed(chirp) {
+		return false
+	}
+	switch {
+	case chirp.Visibility == entities.VisibilityPublic:
+		return true
+	case viewerId == nil:
+		return false
+	case *viewerId == chirp.UserId:
+		return true
+	case chirp.Visibility == entities.VisibilityFollowers:
+		_, follows := tx.db.idx.followers[chirp.UserId][*viewerId]
+		return follows
+	case chirp.Visibility == entities.VisibilityDirect:
+		return chirp.MentionsUser(*viewerId)
+	}
+	return false
+}
+
+// chirpFilter tells which chirps a viewer sees, see Tx.ChirpFilter
+type chirpFilter struct {
+	tx       *Tx
+	viewerId *int
+	excluded map[int]struct{}
+}
+
+// ChirpFilter returns the filter of the chirps viewerId sees in listings:
+// the readable ones by authors not excluded for the viewer
+func (tx *Tx) ChirpFilter(viewerId *int) chirpFilter {
+	return chirpFilter{tx: tx, viewerId: viewerId, excluded: tx.ExcludedAuthors(viewerId)}
+}
+
+// Allows tells whether the viewer of f sees chirp
+func (f chirpFilter) Allows(chirp entities.Chirp) bool {
+	_, skip := f.excluded[chirp.UserId]
+	return !skip && f.tx.Readable(chirp, f.viewerId)
+}
+
+// Likes returns the ids of the users who liked chirpId
+func (tx *Tx) Likes(chirpId int) []int {
+	return slices.Clone(tx.db.data.Likes[chirpId])
+}
+
+// PutLikes replaces the users who liked chirpId
+func (tx *Tx) PutLikes(chirpId int, userIds []int) error {
+	if len(userIds) == 0 {
+		if _, ok := tx.db.data.Likes[chirpId]; !ok {
+			return nil
+		}
+		return tx.apply(journalEntry{Op: opDeleteLikes, Id: chirpId})
+	}
+	return tx.apply(journalEntry{Op: opPutLikes, Id: chirpId, Ids: userIds})
+}
+
+// NextRechirpId reserves the id for a new rechirp
+func (tx *Tx) NextRechirpId() int {
+	tx.db.rechirpLastId += 1
+	return tx.db.rechirpLastId
+}
+
+// Rechirp returns the rechirp of chirpId by userId
+func (tx *Tx) Rechirp(userId, chirpId int) (entities.Rechirp, bool) {
+	id, ok := tx.db.idx.rechirpByUserChirp[[2]int{userId, chirpId}]
+	if !ok {
+		return entities.Rechirp{}, false
+	}
+	return tx.db.data.Rechirps[id], true
+}
+
+// ChirpRechirps returns the rechirps of chirpId
+func (tx *Tx) ChirpRechirps(chirpId int) []entities.Rechirp {
+	return tx.rechirps(tx.db.idx.rechirpsByChirp[chirpId])
+}
+
+// UserRechirps returns the rechirps made by userId
+func (tx *Tx) UserRechirps(userId int) []entities.Rechirp {
+	return tx.rechirps(tx.db.idx.rechirpsByUser[userId])
+}
+
+func (tx *Tx) rechirps(ids map[int]struct{}) []entities.Rechirp {
+	rechirps := make([]entities.Rechirp, 0, len(ids))
+	for id := range ids {
+		rechirps = append(rechirps, tx.db.data.Rechirps[id])
+	}
+	return rechirps
+}
+
+func (tx *Tx) PutRechirp(rechirp entities.Rechirp) error {
+	return tx.apply(journalEntry{Op: opPutRechirp, Rechirp: &rechirp})
+}
+
+func (tx *Tx) DeleteRechirp(id int) error {
+	return tx.apply(journalEntry{Op: opDeleteRechirp, Id: id})
+}
+
+// ChirpStats counts the likes and rechirps of chirpId,
+// LikedByMe is set for viewerId when not nil
+func (tx *Tx) ChirpStats(chirpId int, viewerId *int) entities.ChirpStats {
+	stats := entities.ChirpStats{
+		LikeCount:    len(tx.db.data.Likes[chirpId]),
+		RechirpCount: len(tx.db.idx.rechirpsByChirp[chirpId]),
+	}
+	if viewerId != nil {
+		_, stats.LikedByMe = tx.db.idx.likesByUser[*viewerId][chirpId]
+	}
+	return stats
+}
+
+// NextReportId reserves the id for a new report
+func (tx *Tx) NextReportId() int {
+	tx.db.reportLastId += 1
+	return tx.db.reportLastId
+}
+
+func (tx *Tx) Report(id int) (entities.Report, bool) {
+	report, ok := tx.db.data.Reports[id]
+	return report, ok
+}
+
+// Reports returns every report, oldest first
+func (tx *Tx) Reports() []entities.Report {
+	reports := make([]entities.Report, 0, len(tx.db.data.Reports))
+	for _, r := range tx.db.data.Reports {
+		reports = append(reports, r)
+	}
+	slices.SortFunc(reports, func(a, b entities.Report) int { return a.Id - b.Id })
+	return reports
+}
+
+// ChirpReports returns the reports about chirpId
+func (tx *Tx) ChirpReports(chirpId int) []entities.Report {
+	return tx.reports(tx.db.idx.reportsByChirp[chirpId])
+}
+
+// UserReports returns the reports about userId or their chirps
+func (tx *Tx) UserReports(userId int) []entities.Report {
+	return tx.reports(tx.db.idx.reportsByUser[userId])
+}
+
+func (tx *Tx) reports(ids map[int]struct{}) []entities.Report {
+	reports := make([]entities.Report, 0, len(ids))
+	for id := range ids {
+		reports = append(reports, tx.db.data.Reports[id])
+	}
+	return reports
+}
+
+func (tx *Tx) PutReport(report entities.Report) error {
+	return tx.apply(journalEntry{Op: opPutReport, Report: &report})
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+// concurrentWriters is enough for the JSON journal to be compacted along the way
+const concurrentWriters = 100
+
+// createChirpsConcurrently creates concurrentWriters chirps of userId
+// from as many goroutines and returns the ids they got
+func createChirpsConcurrently(t *testing.T, db Store, userId int) []int {
+	t.Helper()
+	ids := make([]int, concurrentWriters)
+	errs := make([]error, concurrentWriters)
+	var wg sync.WaitGroup
+	for i := range concurrentWriters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			chirp, err := db.CreateChirp(entities.Chirp{UserId: userId, Body: fmt.Sprintf("chirp %d", i)})
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			ids[i] = chirp.Id
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("chirp %d: %v", i, err)
+		}
+	}
+	return ids
+}
+
+// checkChirps fails unless db holds exactly the chirps with the given ids
+func checkChirps(t *testing.T, db Store, ids []int) {
+	t.Helper()
+	seen := map[int]bool{}
+	for _, id := range ids {
+		if seen[id] {
+			t.Fatalf("id %d assigned twice", id)
+		}
+		seen[id] = true
+	}
+	chirps, err := db.GetChirps(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chirps) != len(ids) {
+		t.Fatalf("got %d chirps, want %d", len(chirps), len(ids))
+	}
+	for _, c := range chirps {
+		if !seen[c.Id] {
+			t.Fatalf("unexpected chirp %d", c.Id)
+		}
+	}
+}
+
+func TestConcurrentUpdatesMemory(t *testing.T) {
+	db := NewMemoryDB()
+	user, err := db.CreateUser("a@example.com", "hash", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkChirps(t, db, createChirpsConcurrently(t, db, user.Id))
+}
+
+func TestConcurrentUpdatesJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	db, err := NewDB(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := db.CreateUser("a@example.com", "hash", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := createChirpsConcurrently(t, db, user.Id)
+	checkChirps(t, db, ids)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewDB(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	checkChirps(t, reopened, ids)
+}
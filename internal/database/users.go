@@ -1,69 +1,112 @@
 package database
 
-import "github.com/sp3dr4/chirpy/internal/entities"
+import (
+	"errors"
+	"fmt"
 
-func findUserByEmail(users map[int]entities.User, email string) (*entities.User, bool) {
-	for _, u := range users {
-		if u.Email == email {
-			return &u, true
-		}
+	"github.com/sp3dr4/chirpy/internal/entities"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+var ErrDuplicateHandle = errors.New("handle already taken")
+
+// uniqueHandle returns base, or base followed by the smallest number
+// making it a handle that is not taken yet
+func uniqueHandle(base string, taken func(handle string) bool) string {
+	handle := base
+	for n := 2; taken(handle); n++ {
+		suffix := fmt.Sprint(n)
+		handle = base[:min(len(base), entities.MaxHandleLength-len(suffix))] + suffix
 	}
-	return nil, false
+	return handle
 }
 
 // CreateUser creates a new user and saves it to disk
 func (db *DB) CreateUser(email, password string, isChirpyRed bool) (*entities.User, error) {
-	dbObj, err := db.loadDB()
+	var user entities.User
+	err := db.Update(func(tx *Tx) error {
+		if _, exists := tx.UserByEmail(email); exists {
+			return ErrDuplicateUser
+		}
+		handle := uniqueHandle(entities.HandleFromEmail(email), func(h string) bool {
+			_, taken := tx.userIdByHandle(h)
+			return taken
+		})
+		user = entities.User{
+			Id:          tx.NextUserId(),
+			Email:       email,
+			Password:    password,
+			IsChirpyRed: isChirpyRed,
+			Handle:      handle,
+		}
+		return tx.PutUser(user)
+	})
 	if err != nil {
 		return nil, err
 	}
-
-	if _, exists := findUserByEmail(dbObj.Users, email); exists {
-		return nil, ErrDuplicateUser
-	}
-
-	db.userIdMux.Lock()
-	db.userLastId += 1
-	db.userIdMux.Unlock()
-	user := entities.User{
-		Id:          db.userLastId,
-		Email:       email,
-		Password:    password,
-		IsChirpyRed: isChirpyRed,
-	}
-
-	dbObj.Users[user.Id] = user
-	if err = db.writeDB(*dbObj); err != nil {
-		return nil, err
-	}
 	return &user, nil
 }
 
 // GetUsers returns all users in the database
 func (db *DB) GetUsers() ([]entities.User, error) {
-	dbObj, err := db.loadDB()
+	var users []entities.User
+	err := db.View(func(tx *Tx) error {
+		users = tx.Users()
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	users := make([]entities.User, 0, len(dbObj.Users))
-	for _, value := range dbObj.Users {
-		users = append(users, value)
-	}
 	return users, nil
 }
 
-// UpdateUser updates a user attributes and returns it
-func (db *DB) UpdateUser(user *entities.User) (*entities.User, error) {
-	dbObj, err := db.loadDB()
+// GetUserByID returns the user with the given id
+func (db *DB) GetUserByID(id int) (*entities.User, error) {
+	var user entities.User
+	err := db.View(func(tx *Tx) error {
+		var found bool
+		if user, found = tx.User(id); !found {
+			return ErrUserNotFound
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	for i, u := range dbObj.Users {
-		if u.Id == user.Id {
-			dbObj.Users[i] = *user
+	return &user, nil
+}
+
+// GetUserByEmail returns the user registered with the given email
+func (db *DB) GetUserByEmail(email string) (*entities.User, error) {
+	var user entities.User
+	err := db.View(func(tx *Tx) error {
+		var found bool
+		if user, found = tx.UserByEmail(email); !found {
+			return ErrUserNotFound
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	if err = db.writeDB(*dbObj); err != nil {
+	return &user, nil
+}
+
+// UpdateUser updates a user attributes and returns it
+func (db *DB) UpdateUser(user *entities.User) (*entities.User, error) {
+	err := db.Update(func(tx *Tx) error {
+		if _, exists := tx.User(user.Id); !exists {
+			return nil
+		}
+		if other, exists := tx.UserByEmail(user.Email); exists && other.Id != user.Id {
+			return ErrDuplicateUser
+		}
+		if other, exists := tx.UserByHandle(user.Handle); exists && other.Id != user.Id {
+			return ErrDuplicateHandle
+		}
+		return tx.PutUser(*user)
+	})
+	if err != nil {
 		return nil, err
 	}
 	return user, nil
@@ -2,27 +2,184 @@ package entities
 
 import (
 	"errors"
+	"fmt"
+	"regexp"
 	"slices"
 	"strings"
+	"time"
+	"unicode"
+
+	"github.com/rivo/uniseg"
+	"golang.org/x/text/unicode/norm"
+)
+
+const MaxChirpLength = 140
+const urlWeight = 23
+const maxHashtagLength = 50
+
+// rules reported by ValidationError
+const (
+	RuleEmpty            = "empty"
+	RuleControlCharacter = "control_character"
+	RuleTooLong          = "too_long"
 )
 
-var profanities []string = []string{"kerfuffle", "sharbert", "fornax"}
+// Visibility tells who may read a chirp
+type Visibility string
+
+const (
+	VisibilityPublic    Visibility = "public"
+	VisibilityFollowers Visibility = "followers"
+	// direct chirps are addressed to the users they @mention
+	VisibilityDirect Visibility = "direct"
+)
+
+var ErrInvalidVisibility = errors.New("visibility must be public, followers or direct")
+
+var urlPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s]+`)
+
+// hashtags and mentions must not be glued to a preceding word,
+// so that emails and anchors such as "a@b.com" or "x#y" are ignored
+var hashtagPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&#])#([\p{L}\p{N}_]+)`)
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_.@])@([A-Za-z0-9_]+)`)
 
 type Chirp struct {
-	Id     int    `json:"id"`
-	Body   string `json:"body"`
-	UserId int    `json:"author_id"`
+	Id        int       `json:"id"`
+	Body      string    `json:"body"`
+	UserId    int       `json:"author_id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// InReplyTo and RootId are nil for chirps starting a conversation.
+	// They keep pointing to their chirps after those are deleted.
+	InReplyTo *int `json:"in_reply_to,omitempty"`
+	RootId    *int `json:"root_id,omitempty"`
+	// Hashtags and Mentions are parsed from the body whenever it is saved
+	Hashtags []string  `json:"hashtags,omitempty"`
+	Mentions []Mention `json:"mentions,omitempty"`
+	// Flags name the moderation rules that flagged the chirp for review
+	Flags []string `json:"flags,omitempty"`
+	// Hidden chirps were taken down by an admin, only their author sees them
+	Hidden     bool       `json:"hidden,omitempty"`
+	Visibility Visibility `json:"visibility"`
+}
+
+// Mention is a user referenced in a chirp body as @handle
+type Mention struct {
+	Handle string `json:"handle"`
+	UserId int    `json:"user_id"`
+}
+
+// HashtagCount is the number of recent chirps using a hashtag
+type HashtagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// ThreadRootId returns the id of the chirp that started the conversation
+func (c Chirp) ThreadRootId() int {
+	if c.RootId != nil {
+		return *c.RootId
+	}
+	return c.Id
+}
+
+// MentionsUser tells whether userId is @mentioned in the chirp
+func (c Chirp) MentionsUser(userId int) bool {
+	return slices.ContainsFunc(c.Mentions, func(m Mention) bool { return m.UserId == userId })
+}
+
+// ParseVisibility reads a visibility, public when empty
+func ParseVisibility(value string) (Visibility, error) {
+	switch v := Visibility(value); v {
+	case "":
+		return VisibilityPublic, nil
+	case VisibilityPublic, VisibilityFollowers, VisibilityDirect:
+		return v, nil
+	}
+	return "", ErrInvalidVisibility
 }
 
+// ChirpRevision is a body a chirp had before being edited
+type ChirpRevision struct {
+	ChirpId  int       `json:"chirp_id"`
+	Body     string    `json:"body"`
+	PostedAt time.Time `json:"posted_at"`
+	EditedAt time.Time `json:"edited_at"`
+}
+
+// ValidationError tells which rule a chirp body breaks
+type ValidationError struct {
+	Rule    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// lineEndings turns Windows and old Mac line endings into \n
+var lineEndings = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+// ValidateChirp normalizes a chirp body to NFC with \n line endings and
+// checks its shape, its content is up to the moderation rules. Length is
+// counted in user-perceived characters, every URL weighing urlWeight.
 func ValidateChirp(text string) (string, error) {
-	if len(text) > 140 {
-		return "", errors.New("chirp is too long")
+	text = norm.NFC.String(lineEndings.Replace(text))
+	if strings.TrimSpace(text) == "" {
+		return "", &ValidationError{Rule: RuleEmpty, Message: "chirp is empty"}
+	}
+	if i := strings.IndexFunc(text, func(r rune) bool { return unicode.IsControl(r) && r != '\n' && r != '\t' }); i != -1 {
+		return "", &ValidationError{
+			Rule:    RuleControlCharacter,
+			Message: fmt.Sprintf("chirp contains the control character %U", []rune(text[i:])[0]),
+		}
+	}
+	if length := ChirpLength(text); length > MaxChirpLength {
+		return "", &ValidationError{
+			Rule:    RuleTooLong,
+			Message: fmt.Sprintf("chirp is %d characters long, the limit is %d", length, MaxChirpLength),
+		}
+	}
+	return text, nil
+}
+
+// ChirpLength counts the grapheme clusters of text, so that an emoji or
+// an accented letter is one character whatever its encoding.
+// URLs count as urlWeight characters, however long.
+func ChirpLength(text string) int {
+	length := 0
+	last := 0
+	for _, span := range urlPattern.FindAllStringIndex(text, -1) {
+		length += uniseg.GraphemeClusterCount(text[last:span[0]]) + urlWeight
+		last = span[1]
+	}
+	return length + uniseg.GraphemeClusterCount(text[last:])
+}
+
+// ParseHashtags returns the lowercased #hashtags of a chirp body without
+// the leading #, in order of first appearance. Numbers such as #1 are not tags.
+func ParseHashtags(text string) []string {
+	tags := []string{}
+	for _, m := range hashtagPattern.FindAllStringSubmatch(text, -1) {
+		tag := strings.ToLower(m[1])
+		if len(tag) > maxHashtagLength || strings.Trim(tag, "0123456789") == "" || slices.Contains(tags, tag) {
+			continue
+		}
+		tags = append(tags, tag)
 	}
-	words := strings.Split(text, " ")
-	for i, word := range words {
-		if slices.Contains(profanities, strings.ToLower(word)) {
-			words[i] = "****"
+	return tags
+}
+
+// ParseMentions returns the lowercased handles @mentioned in a chirp body,
+// in order of first appearance
+func ParseMentions(text string) []string {
+	handles := []string{}
+	for _, m := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		handle := strings.ToLower(m[1])
+		if ValidateHandle(handle) != nil || slices.Contains(handles, handle) {
+			continue
 		}
+		handles = append(handles, handle)
 	}
-	return strings.Join(words, " "), nil
+	return handles
 }
@@ -0,0 +1,104 @@
+package entities
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestChirpLength(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"hello", 5},
+		{"café", 4},
+		// e followed by a combining acute accent
+		{"cafe\u0301", 4},
+		{"👍", 1},
+		{"👍🏽", 1},
+		// family: man, woman, girl joined by zero width joiners
+		{"👨\u200d👩\u200d👧", 1},
+		{"🇫🇷", 1},
+		{"https://example.com", urlWeight},
+		{"see https://example.com/" + strings.Repeat("a", 200) + " now", 4 + urlWeight + 4},
+		{"http://a.b and HTTPS://C.D", 2*urlWeight + 5},
+	}
+	for _, tt := range tests {
+		if got := ChirpLength(tt.text); got != tt.want {
+			t.Errorf("ChirpLength(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestValidateChirpNormalizesToNFC(t *testing.T) {
+	got, err := ValidateChirp("cafe\u0301")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "caf\u00e9" {
+		t.Fatalf("got %q, want the precomposed é", got)
+	}
+}
+
+func TestValidateChirpRules(t *testing.T) {
+	tests := []struct {
+		body string
+		rule string
+	}{
+		{strings.Repeat("a", MaxChirpLength), ""},
+		{strings.Repeat("a", MaxChirpLength+1), RuleTooLong},
+		{strings.Repeat("👨\u200d👩\u200d👧", MaxChirpLength), ""},
+		{strings.Repeat("👨\u200d👩\u200d👧", MaxChirpLength+1), RuleTooLong},
+		{"https://example.com/" + strings.Repeat("a", 200) + " " + strings.Repeat("a", MaxChirpLength-urlWeight-1), ""},
+		{"https://example.com/" + strings.Repeat("a", 200) + " " + strings.Repeat("a", MaxChirpLength-urlWeight), RuleTooLong},
+		{"", RuleEmpty},
+		{" \n\t ", RuleEmpty},
+		{"\r\n", RuleEmpty},
+	}
+	for _, tt := range tests {
+		_, err := ValidateChirp(tt.body)
+		if tt.rule == "" {
+			if err != nil {
+				t.Errorf("ValidateChirp of %d bytes: %v", len(tt.body), err)
+			}
+			continue
+		}
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) || validationErr.Rule != tt.rule {
+			t.Errorf("ValidateChirp of %d bytes: got %v, want rule %s", len(tt.body), err, tt.rule)
+		}
+	}
+}
+
+func TestValidateChirpWhitespace(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{"first line\r\nsecond line", "first line\nsecond line"},
+		{"first line\rsecond line", "first line\nsecond line"},
+		{"first line\nsecond line", "first line\nsecond line"},
+		{"name\tvalue", "name\tvalue"},
+	}
+	for _, tt := range tests {
+		got, err := ValidateChirp(tt.body)
+		if err != nil {
+			t.Errorf("ValidateChirp(%q): %v", tt.body, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ValidateChirp(%q) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestValidateChirpControlCharacters(t *testing.T) {
+	for _, body := range []string{"bell\a", "null\x00byte", "escape\x1b[31m"} {
+		_, err := ValidateChirp(body)
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) || validationErr.Rule != RuleControlCharacter {
+			t.Errorf("ValidateChirp(%q): got %v, want a control character error", body, err)
+		}
+	}
+}
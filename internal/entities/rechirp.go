@@ -0,0 +1,34 @@
+package entities
+
+import "time"
+
+// Rechirp is a user sharing someone's chirp with their followers
+type Rechirp struct {
+	Id        int       `json:"id"`
+	ChirpId   int       `json:"chirp_id"`
+	UserId    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ChirpStats counts the likes and rechirps of a chirp. LikedByMe is
+// only meaningful when the stats were computed for a given user.
+type ChirpStats struct {
+	LikeCount    int
+	RechirpCount int
+	LikedByMe    bool
+}
+
+// TimelineItem is a chirp of a home timeline. It was either posted by a
+// followed user or, when Rechirp is set, rechirped by one.
+type TimelineItem struct {
+	Chirp   Chirp
+	Rechirp *Rechirp
+}
+
+// At is when the item entered the timeline
+func (i TimelineItem) At() time.Time {
+	if i.Rechirp != nil {
+		return i.Rechirp.CreatedAt
+	}
+	return i.Chirp.CreatedAt
+}
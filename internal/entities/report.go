@@ -0,0 +1,44 @@
+package entities
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const MaxReportReasonLength = 500
+
+var ErrInvalidReportReason = errors.New("report reason must be 1 to 500 characters")
+
+type ReportStatus string
+
+const (
+	ReportOpen          ReportStatus = "open"
+	ReportDismissed     ReportStatus = "dismissed"
+	ReportChirpHidden   ReportStatus = "chirp_hidden"
+	ReportUserSuspended ReportStatus = "user_suspended"
+)
+
+// Report asks the admins to review a chirp or a user
+type Report struct {
+	Id int `json:"id"`
+	// ReporterId is nil for the reports filed by the moderation rules
+	ReporterId *int `json:"reporter_id"`
+	// ChirpId is nil when a user is reported, UserId is then that user.
+	// For chirp reports UserId is the author of the chirp.
+	ChirpId    *int         `json:"chirp_id,omitempty"`
+	UserId     int          `json:"user_id"`
+	Reason     string       `json:"reason"`
+	Status     ReportStatus `json:"status"`
+	CreatedAt  time.Time    `json:"created_at"`
+	ResolvedAt *time.Time   `json:"resolved_at,omitempty"`
+}
+
+func ValidateReportReason(reason string) (string, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" || utf8.RuneCountInString(reason) > MaxReportReasonLength {
+		return "", ErrInvalidReportReason
+	}
+	return reason, nil
+}
@@ -0,0 +1,63 @@
+package entities
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// Session is a login of a user on a device. It is identified by its
+// refresh token, of which only the hash is kept. The refresh token is
+// rotated on every use, the session is the family of all its tokens.
+type Session struct {
+	Id         int       `json:"id"`
+	UserId     int       `json:"user_id"`
+	TokenHash  string    `json:"token_hash"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// RetiredHashes are the hashes of the refresh tokens rotated out,
+	// presenting one again revokes the session
+	RetiredHashes []string `json:"retired_hashes,omitempty"`
+	// AccessTokens are the access tokens issued to the session, they are
+	// revoked along with it
+	AccessTokens []AccessToken `json:"access_tokens,omitempty"`
+}
+
+// AccessToken is an access token, identified by its jti claim
+type AccessToken struct {
+	Id        string    `json:"id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// SecurityEventKind tells what happened in a security event
+type SecurityEventKind string
+
+const (
+	// SecurityEventTokenReuse is a rotated refresh token presented again,
+	// most likely because it was stolen
+	SecurityEventTokenReuse SecurityEventKind = "refresh_token_reuse"
+)
+
+// SecurityEvent records a suspicious use of the account of a user
+type SecurityEvent struct {
+	Id        int               `json:"id"`
+	UserId    int               `json:"user_id"`
+	Kind      SecurityEventKind `json:"kind"`
+	SessionId int               `json:"session_id"`
+	IP        string            `json:"ip"`
+	UserAgent string            `json:"user_agent"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// HashToken returns the hash a session keeps of its refresh token. It is
+// keyed, so that a leaked database does not let anyone check guessed
+// tokens without the key.
+func HashToken(key []byte, token string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,23 @@
+package entities
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestHashTokenIsKeyed(t *testing.T) {
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte("token"))
+	if got, want := HashToken([]byte("key"), "token"), hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Fatalf("got %s, want the HMAC-SHA256 %s", got, want)
+	}
+	if HashToken([]byte("key"), "token") == HashToken([]byte("other key"), "token") {
+		t.Fatal("the hash does not depend on the key")
+	}
+	unkeyed := sha256.Sum256([]byte("token"))
+	if HashToken([]byte("key"), "token") == hex.EncodeToString(unkeyed[:]) {
+		t.Fatal("the hash is a plain SHA-256")
+	}
+}
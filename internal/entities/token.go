@@ -1,9 +0,0 @@
-package entities
-
-import "time"
-
-type RefreshToken struct {
-	UserId    int       `json:"userId"`
-	Token     string    `json:"token"`
-	ExpiresAt time.Time `json:"expiresAt"`
-}
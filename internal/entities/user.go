@@ -1,8 +1,43 @@
 package entities
 
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+const MaxHandleLength = 20
+
+var ErrInvalidHandle = errors.New("handle must be 1 to 20 lowercase letters, digits or underscores")
+
+var handlePattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+var handleInvalidChars = regexp.MustCompile(`[^a-z0-9_]+`)
+
 type User struct {
 	Id          int    `json:"id"`
 	Email       string `json:"email"`
 	Password    string `json:"password"`
 	IsChirpyRed bool   `json:"is_chirpy_red"`
+	// Handle is the unique name other users @mention
+	Handle string `json:"handle"`
+	// Suspended users cannot log in and their chirps are not listed
+	Suspended bool `json:"suspended,omitempty"`
+}
+
+func ValidateHandle(handle string) error {
+	if len(handle) > MaxHandleLength || !handlePattern.MatchString(handle) {
+		return ErrInvalidHandle
+	}
+	return nil
+}
+
+// HandleFromEmail derives a default handle from the local part of an email.
+// It is valid but not necessarily unique.
+func HandleFromEmail(email string) string {
+	local, _, _ := strings.Cut(strings.ToLower(email), "@")
+	handle := strings.Trim(handleInvalidChars.ReplaceAllString(local, "_"), "_")
+	if handle == "" {
+		handle = "user"
+	}
+	return handle[:min(len(handle), MaxHandleLength)]
 }
@@ -0,0 +1,85 @@
+// Package fixtures seeds a store with a known dataset, so that every
+// developer and integration test starts from the same state.
+package fixtures
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sp3dr4/chirpy/internal/database"
+	"github.com/sp3dr4/chirpy/internal/entities"
+	"github.com/sp3dr4/chirpy/internal/moderation"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// Fixtures is the content of a fixture file. JSON files are accepted too,
+// since JSON is valid YAML.
+type Fixtures struct {
+	Users  []User  `yaml:"users"`
+	Chirps []Chirp `yaml:"chirps"`
+}
+
+type User struct {
+	Email       string `yaml:"email"`
+	Password    string `yaml:"password"`
+	IsChirpyRed bool   `yaml:"is_chirpy_red"`
+}
+
+// Chirp references its author by email, ids are assigned by the store
+type Chirp struct {
+	Author string `yaml:"author"`
+	Body   string `yaml:"body"`
+}
+
+// Load reads and parses the fixture file at path
+func Load(path string) (*Fixtures, error) {
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &Fixtures{}
+	if err := yaml.Unmarshal(dat, f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+// Apply creates the fixture users and chirps in store, hashing passwords,
+// validating chirps and running them through moderator the same way the
+// API does
+func (f *Fixtures) Apply(store database.Store, moderator *moderation.Moderator) error {
+	userIds := map[string]int{}
+	for _, u := range f.Users {
+		email := strings.ToLower(u.Email)
+		paswHash, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		user, err := store.CreateUser(email, string(paswHash), u.IsChirpyRed)
+		if err != nil {
+			return fmt.Errorf("user %s: %w", email, err)
+		}
+		userIds[email] = user.Id
+	}
+	for i, c := range f.Chirps {
+		userId, ok := userIds[strings.ToLower(c.Author)]
+		if !ok {
+			return fmt.Errorf("chirp %d: unknown author %s", i, c.Author)
+		}
+		cleaned, err := entities.ValidateChirp(c.Body)
+		if err != nil {
+			return fmt.Errorf("chirp %d: %w", i, err)
+		}
+		moderated := moderator.Moderate(cleaned)
+		if moderated.Rejected != "" {
+			return fmt.Errorf("chirp %d: rejected by moderation rule %q", i, moderated.Rejected)
+		}
+		chirp := entities.Chirp{UserId: userId, Body: moderated.Text, Flags: moderated.Flags}
+		if _, err := store.CreateChirp(chirp); err != nil {
+			return fmt.Errorf("chirp %d: %w", i, err)
+		}
+	}
+	return nil
+}
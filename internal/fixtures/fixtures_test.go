@@ -0,0 +1,53 @@
+package fixtures
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sp3dr4/chirpy/internal/database"
+	"github.com/sp3dr4/chirpy/internal/moderation"
+)
+
+func TestApplyModeratesChirps(t *testing.T) {
+	moderator, err := moderation.NewModerator(filepath.Join(t.TempDir(), "moderation.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &Fixtures{
+		Users:  []User{{Email: "a@example.com", Password: "pw"}},
+		Chirps: []Chirp{{Author: "a@example.com", Body: "what a kerfuffle"}},
+	}
+	db := database.NewMemoryDB()
+	if err := f.Apply(db, moderator); err != nil {
+		t.Fatal(err)
+	}
+	chirps, err := db.GetChirps(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chirps) != 1 || chirps[0].Body != "what a ****" {
+		t.Fatalf("got %+v, want the masked chirp", chirps)
+	}
+}
+
+func TestApplyRejectsModeratedChirps(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "moderation.yaml")
+	if err := os.WriteFile(path, []byte("rules:\n  - name: no-spam\n    action: reject\n    words: [spam]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	moderator, err := moderation.NewModerator(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &Fixtures{
+		Users:  []User{{Email: "a@example.com", Password: "pw"}},
+		Chirps: []Chirp{{Author: "a@example.com", Body: "buy spam"}},
+	}
+	err = f.Apply(database.NewMemoryDB(), moderator)
+	if err == nil || !strings.Contains(err.Error(), "no-spam") {
+		t.Fatalf("got %v, want the rejection by no-spam", err)
+	}
+}
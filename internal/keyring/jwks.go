@@ -0,0 +1,86 @@
+package keyring
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"time"
+)
+
+// JWK is the public part of a key, as published in a JSON Web Key Set (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	// Crv and X are set for Ed25519 keys
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	// N and E are set for RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// publicJWK holds the members of the key a thumbprint covers
+func publicJWK(pub crypto.PublicKey) JWK {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return JWK{Kty: "RSA", N: encode(pub.N.Bytes()), E: encode(big.NewInt(int64(pub.E)).Bytes())}
+	case ed25519.PublicKey:
+		return JWK{Kty: "OKP", Crv: "Ed25519", X: encode(pub)}
+	}
+	return JWK{}
+}
+
+// thumbprint is the JWK thumbprint (RFC 7638) of pub, its required
+// members serialized in lexicographic order and hashed with SHA-256
+func thumbprint(pub crypto.PublicKey) string {
+	jwk := publicJWK(pub)
+	var members any
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+	dat, _ := json.Marshal(members)
+	sum := sha256.Sum256(dat)
+	return encode(sum[:])
+}
+
+// JWKS returns the public keys verifying the tokens, the active one included
+func (k *Keyring) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	now := time.Now()
+	for _, key := range *k.keys.Load() {
+		if !k.verifies(key, now) {
+			continue
+		}
+		jwk := publicJWK(key.Public())
+		jwk.Use = "sig"
+		jwk.Alg = string(key.Algorithm)
+		jwk.Kid = key.Id
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
@@ -0,0 +1,242 @@
+// Package keyring keeps the asymmetric keys signing the access tokens:
+// the newest key signs, the ones it replaced still verify until they expire.
+package keyring
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+type Algorithm string
+
+const (
+	RS256 Algorithm = "RS256"
+	EdDSA Algorithm = "EdDSA"
+)
+
+const rsaKeyBits = 2048
+
+var ErrUnsupportedAlgorithm = errors.New("signing algorithm must be RS256 or EdDSA")
+var ErrNoKeys = errors.New("keyring has no keys")
+
+// Key is a signing key identified by the JWK thumbprint of its public part
+type Key struct {
+	Id        string    `json:"kid"`
+	Algorithm Algorithm `json:"alg"`
+	CreatedAt time.Time `json:"created_at"`
+	// RetiredAt is when a newer key took over signing
+	RetiredAt *time.Time `json:"retired_at,omitempty"`
+	// PrivateKey is the PKCS #8 PEM encoding of the key
+	PrivateKey string `json:"private_key"`
+
+	signer crypto.Signer
+}
+
+// Signer returns the private key, an *rsa.PrivateKey or an ed25519.PrivateKey
+func (k Key) Signer() crypto.Signer {
+	return k.signer
+}
+
+// Public returns the key verifying the signatures of k
+func (k Key) Public() crypto.PublicKey {
+	return k.signer.Public()
+}
+
+type keyFile struct {
+	Keys []Key `json:"keys"`
+}
+
+func generate(alg Algorithm) (Key, error) {
+	var signer crypto.Signer
+	var err error
+	switch alg {
+	case RS256:
+		signer, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case EdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return Key{}, ErrUnsupportedAlgorithm
+	}
+	if err != nil {
+		return Key{}, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return Key{}, err
+	}
+	key := Key{
+		Algorithm:  alg,
+		CreatedAt:  time.Now().UTC(),
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		signer:     signer,
+	}
+	key.Id = thumbprint(key.Public())
+	return key, nil
+}
+
+// parse decodes the private key of k and checks it matches the algorithm and id
+func (k *Key) parse() error {
+	block, _ := pem.Decode([]byte(k.PrivateKey))
+	if block == nil {
+		return fmt.Errorf("key %s: no PEM private key", k.Id)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("key %s: %w", k.Id, err)
+	}
+	switch parsed.(type) {
+	case *rsa.PrivateKey:
+		if k.Algorithm != RS256 {
+			return fmt.Errorf("key %s: RSA key for %s", k.Id, k.Algorithm)
+		}
+	case ed25519.PrivateKey:
+		if k.Algorithm != EdDSA {
+			return fmt.Errorf("key %s: Ed25519 key for %s", k.Id, k.Algorithm)
+		}
+	default:
+		return fmt.Errorf("key %s: %w", k.Id, ErrUnsupportedAlgorithm)
+	}
+	k.signer = parsed.(crypto.Signer)
+	if thumbprint(k.signer.Public()) != k.Id {
+		return fmt.Errorf("key %s: id does not match the key", k.Id)
+	}
+	return nil
+}
+
+// load reads the keys of the file at path, oldest first
+func load(path string) ([]Key, error) {
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := keyFile{}
+	if err := json.Unmarshal(dat, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(f.Keys) == 0 {
+		return nil, fmt.Errorf("%s: %w", path, ErrNoKeys)
+	}
+	for i := range f.Keys {
+		if err := f.Keys[i].parse(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return f.Keys, nil
+}
+
+// save replaces the file at path, readable by its owner only
+func save(path string, keys []Key) error {
+	dat, err := json.MarshalIndent(keyFile{Keys: keys}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(dat); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Rotate adds a new key for alg to the file at path, which signs from now
+// on, and drops the keys retired for longer than retention. It creates the
+// file when it does not exist. Running servers use the new key once reloaded.
+func Rotate(path string, alg Algorithm, retention time.Duration) (Key, error) {
+	keys, err := load(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Key{}, err
+	}
+	key, err := generate(alg)
+	if err != nil {
+		return Key{}, err
+	}
+	kept := []Key{}
+	for _, k := range keys {
+		if k.RetiredAt == nil {
+			k.RetiredAt = &key.CreatedAt
+		}
+		if key.CreatedAt.Sub(*k.RetiredAt) <= retention {
+			kept = append(kept, k)
+		}
+	}
+	if err := save(path, append(kept, key)); err != nil {
+		return Key{}, err
+	}
+	return key, nil
+}
+
+// Keyring holds the keys of a key file.
+// Reload swaps them atomically, requests in flight keep the previous ones.
+type Keyring struct {
+	path string
+	// retention is how long retired keys still verify tokens
+	retention time.Duration
+	keys      atomic.Pointer[[]Key]
+}
+
+// Open loads the key file at path, creating it with
+// a new EdDSA key when it does not exist. Retired keys
+// verify tokens for retention, whether or not a rotation
+// dropped them from the file since.
+func Open(path string, retention time.Duration) (*Keyring, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		if _, err := Rotate(path, EdDSA, 0); err != nil {
+			return nil, err
+		}
+	}
+	k := &Keyring{path: path, retention: retention}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Reload reads the key file again. On error the current keys stay in place.
+func (k *Keyring) Reload() error {
+	keys, err := load(k.path)
+	if err != nil {
+		return err
+	}
+	k.keys.Store(&keys)
+	return nil
+}
+
+// Active returns the key signing new tokens, the newest one
+func (k *Keyring) Active() Key {
+	keys := *k.keys.Load()
+	return keys[len(keys)-1]
+}
+
+// verifies tells whether key still verifies tokens at now
+func (k *Keyring) verifies(key Key, now time.Time) bool {
+	return key.RetiredAt == nil || now.Sub(*key.RetiredAt) <= k.retention
+}
+
+// Lookup returns the key identified by kid, unless it was retired
+// for longer than the retention
+func (k *Keyring) Lookup(kid string) (Key, bool) {
+	for _, key := range *k.keys.Load() {
+		if key.Id == kid && k.verifies(key, time.Now()) {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
@@ -0,0 +1,164 @@
+package keyring
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotatePrunesRetiredKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwt_keys.json")
+	var ids []string
+	for range 3 {
+		key, err := Rotate(path, EdDSA, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, key.Id)
+	}
+	keys, err := load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 || keys[2].Id != ids[2] || keys[2].RetiredAt != nil {
+		t.Fatalf("got %d keys, want the 3 rotated with the last one active", len(keys))
+	}
+
+	// retire the first key two hours ago, past the retention
+	retiredAt := time.Now().Add(-2 * time.Hour).UTC()
+	keys[0].RetiredAt = &retiredAt
+	if err := save(path, keys); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Rotate(path, EdDSA, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	keys, err = load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 || keys[0].Id != ids[1] || keys[1].Id != ids[2] {
+		t.Fatalf("got %d keys, want the first one dropped", len(keys))
+	}
+}
+
+func TestLookupRejectsKeysPastRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwt_keys.json")
+	old, err := Rotate(path, EdDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recent, err := Rotate(path, EdDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	active, err := Rotate(path, EdDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retiredAt := time.Now().Add(-2 * time.Hour).UTC()
+	keys[0].RetiredAt = &retiredAt
+	if err := save(path, keys); err != nil {
+		t.Fatal(err)
+	}
+
+	// no rotation ran since the first key went past the retention
+	k, err := Open(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := k.Lookup(old.Id); ok {
+		t.Error("the key retired past the retention verifies")
+	}
+	for _, key := range []Key{recent, active} {
+		if _, ok := k.Lookup(key.Id); !ok {
+			t.Errorf("key %s does not verify", key.Id)
+		}
+	}
+	for _, jwk := range k.JWKS().Keys {
+		if jwk.Kid == old.Id {
+			t.Error("the key retired past the retention is published")
+		}
+	}
+	if k.Active().Id != active.Id {
+		t.Errorf("got active key %s, want %s", k.Active().Id, active.Id)
+	}
+}
+
+func TestThumbprint(t *testing.T) {
+	// RFC 8037, appendix A
+	seed, _ := base64.RawURLEncoding.DecodeString("nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A")
+	pub := ed25519.NewKeyFromSeed(seed).Public()
+	if x := publicJWK(pub).X; x != "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo" {
+		t.Fatalf("got x %s", x)
+	}
+	if got := thumbprint(pub); got != "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k" {
+		t.Fatalf("got thumbprint %s", got)
+	}
+}
+
+func TestParseRejectsMismatchedKeys(t *testing.T) {
+	ed, err := generate(EdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsa, err := generate(RS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]Key{
+		"ed25519 key for RS256": {Id: ed.Id, Algorithm: RS256, PrivateKey: ed.PrivateKey},
+		"RSA key for EdDSA":     {Id: rsa.Id, Algorithm: EdDSA, PrivateKey: rsa.PrivateKey},
+		"id of another key":     {Id: rsa.Id, Algorithm: EdDSA, PrivateKey: ed.PrivateKey},
+		"no PEM":                {Id: ed.Id, Algorithm: EdDSA, PrivateKey: "key"},
+	}
+	for name, key := range tests {
+		if err := key.parse(); err == nil {
+			t.Errorf("%s: parsed", name)
+		}
+	}
+	for _, key := range []Key{ed, rsa} {
+		parsed := Key{Id: key.Id, Algorithm: key.Algorithm, PrivateKey: key.PrivateKey}
+		if err := parsed.parse(); err != nil {
+			t.Errorf("%s: %v", key.Algorithm, err)
+		}
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwt_keys.json")
+	ed, err := Rotate(path, EdDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsa, err := Rotate(path, RS256, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := Open(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := k.JWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("got %d keys, want 2", len(set.Keys))
+	}
+	want := []JWK{
+		{Kty: "OKP", Use: "sig", Alg: "EdDSA", Kid: ed.Id, Crv: "Ed25519", X: publicJWK(ed.Public()).X},
+		{Kty: "RSA", Use: "sig", Alg: "RS256", Kid: rsa.Id, N: publicJWK(rsa.Public()).N, E: "AQAB"},
+	}
+	for i, jwk := range set.Keys {
+		if jwk != want[i] {
+			t.Errorf("key %d: got %+v, want %+v", i, jwk, want[i])
+		}
+		if jwk.Kid != thumbprint((*k.keys.Load())[i].Public()) {
+			t.Errorf("key %d: kid is not the thumbprint of the key", i)
+		}
+	}
+}
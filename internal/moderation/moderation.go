@@ -0,0 +1,250 @@
+// Package moderation runs chirp bodies through a chain of configurable
+// rules that mask, reject or flag content for review.
+package moderation
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+	"gopkg.in/yaml.v3"
+)
+
+type Action string
+
+const (
+	ActionMask   Action = "mask"
+	ActionReject Action = "reject"
+	ActionFlag   Action = "flag"
+)
+
+const mask = "****"
+
+// Rule matches either words, compared after normalization, or a regex
+type Rule struct {
+	Name   string   `yaml:"name"`
+	Action Action   `yaml:"action"`
+	Words  []string `yaml:"words"`
+	// WordsFile lists one word per line, relative to the rules file.
+	// Blank lines and lines starting with # are ignored.
+	WordsFile string `yaml:"words_file"`
+	Pattern   string `yaml:"pattern"`
+}
+
+type Config struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// DefaultConfig is used when no rules file exists
+var DefaultConfig = Config{
+	Rules: []Rule{
+		{Name: "profanity", Action: ActionMask, Words: []string{"kerfuffle", "sharbert", "fornax"}},
+	},
+}
+
+// Result is the outcome of moderating a text
+type Result struct {
+	// Text has the matches of masking rules replaced
+	Text string
+	// Rejected names the rule rejecting the text, empty when accepted
+	Rejected string
+	// Flags name the rules flagging the text for review
+	Flags []string
+}
+
+// Pipeline is a compiled, immutable rule set, safe for concurrent use
+type Pipeline struct {
+	rules []compiledRule
+}
+
+type compiledRule struct {
+	name   string
+	action Action
+	words  map[string]struct{}
+	re     *regexp.Regexp
+}
+
+// Compile checks and compiles the rules of c, reading word files
+// relative to dir
+func Compile(c Config, dir string) (*Pipeline, error) {
+	p := &Pipeline{}
+	names := map[string]struct{}{}
+	for i, r := range c.Rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("rule %d: missing name", i+1)
+		}
+		if _, dup := names[r.Name]; dup {
+			return nil, fmt.Errorf("rule %q: duplicate name", r.Name)
+		}
+		names[r.Name] = struct{}{}
+		compiled, err := compileRule(r, dir)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+		p.rules = append(p.rules, compiled)
+	}
+	return p, nil
+}
+
+func compileRule(r Rule, dir string) (compiledRule, error) {
+	compiled := compiledRule{name: r.Name, action: r.Action}
+	switch r.Action {
+	case ActionMask, ActionReject, ActionFlag:
+	default:
+		return compiled, fmt.Errorf("unknown action %q", r.Action)
+	}
+	hasWords := len(r.Words) > 0 || r.WordsFile != ""
+	if hasWords == (r.Pattern != "") {
+		return compiled, errors.New("needs either words or a pattern")
+	}
+	if r.Pattern != "" {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return compiled, err
+		}
+		compiled.re = re
+		return compiled, nil
+	}
+
+	words := r.Words
+	if r.WordsFile != "" {
+		fileWords, err := readWords(filepath.Join(dir, r.WordsFile))
+		if err != nil {
+			return compiled, err
+		}
+		words = append(words, fileWords...)
+	}
+	compiled.words = map[string]struct{}{}
+	for _, w := range words {
+		normalized := normalize(w)
+		if normalized == "" || strings.IndexFunc(normalized, func(r rune) bool { return !isWordRune(r) }) != -1 {
+			return compiled, fmt.Errorf("%q is not a single word", w)
+		}
+		compiled.words[normalized] = struct{}{}
+	}
+	return compiled, nil
+}
+
+func readWords(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	words := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	return words, scanner.Err()
+}
+
+// Load reads and compiles the YAML rules file at path
+func Load(path string) (*Pipeline, error) {
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := Config{}
+	if err := yaml.Unmarshal(dat, &c); err != nil {
+		return nil, err
+	}
+	return Compile(c, filepath.Dir(path))
+}
+
+// Moderate runs text through every rule in order. Masks accumulate,
+// so later rules see the masked text, and the first rejection stops.
+func (p *Pipeline) Moderate(text string) Result {
+	res := Result{Text: text, Flags: []string{}}
+	for _, r := range p.rules {
+		spans := r.match(res.Text)
+		if len(spans) == 0 {
+			continue
+		}
+		switch r.action {
+		case ActionReject:
+			res.Rejected = r.name
+			return res
+		case ActionFlag:
+			res.Flags = append(res.Flags, r.name)
+		case ActionMask:
+			res.Text = maskSpans(res.Text, spans)
+		}
+	}
+	return res
+}
+
+// match returns the byte spans of text matched by the rule
+func (r compiledRule) match(text string) [][]int {
+	if r.re != nil {
+		return r.re.FindAllStringIndex(text, -1)
+	}
+	spans := [][]int{}
+	for _, span := range wordSpans(text) {
+		if _, found := r.words[normalize(text[span[0]:span[1]])]; found {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+func maskSpans(text string, spans [][]int) string {
+	var b strings.Builder
+	last := 0
+	for _, span := range spans {
+		b.WriteString(text[last:span[0]])
+		b.WriteString(mask)
+		last = span[1]
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// wordSpans splits text into words, anything else such as
+// punctuation or spaces separates them
+func wordSpans(text string) [][]int {
+	spans := [][]int{}
+	start := -1
+	for i, r := range text {
+		if isWordRune(r) {
+			if start == -1 {
+				start = i
+			}
+		} else if start != -1 {
+			spans = append(spans, []int{start, i})
+			start = -1
+		}
+	}
+	if start != -1 {
+		spans = append(spans, []int{start, len(text)})
+	}
+	return spans
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
+
+// normalize folds compatibility forms, diacritics and case,
+// so that "Ｋérfuffle" and "kerfuffle" compare equal
+func normalize(word string) string {
+	t := transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn)), cases.Fold(), norm.NFC)
+	normalized, _, err := transform.String(t, word)
+	if err != nil {
+		return strings.ToLower(word)
+	}
+	return normalized
+}
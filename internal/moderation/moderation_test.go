@@ -0,0 +1,80 @@
+package moderation
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestModerate(t *testing.T) {
+	p, err := Compile(Config{Rules: []Rule{
+		{Name: "profanity", Action: ActionMask, Words: []string{"kerfuffle", "sharbert"}},
+		{Name: "slurs", Action: ActionReject, Words: []string{"slur"}},
+		{Name: "scams", Action: ActionFlag, Pattern: `(?i)free\s+bitcoin`},
+		{Name: "links", Action: ActionFlag, Words: []string{"example"}},
+	}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		text     string
+		want     string
+		rejected string
+		flags    []string
+	}{
+		{"Kerfuffle!", "****!", "", nil},
+		{"what a KERFUFFLE, really", "what a ****, really", "", nil},
+		// accents and compatibility forms fold away
+		{"kérfuffle", "****", "", nil},
+		{"Ｋｅｒｆｕｆｆｌｅ", "****", "", nil},
+		{"kerfuffle sharbert", "**** ****", "", nil},
+		// whole words only
+		{"kerfuffles", "kerfuffles", "", nil},
+		{"a slur here", "a slur here", "slurs", nil},
+		{"Slúr", "Slúr", "slurs", nil},
+		{"Free  Bitcoin for you", "Free  Bitcoin for you", "", []string{"scams"}},
+		{"free bitcoin at example.com", "free bitcoin at example.com", "", []string{"scams", "links"}},
+		{"hello", "hello", "", nil},
+	}
+	for _, tt := range tests {
+		res := p.Moderate(tt.text)
+		if res.Rejected != tt.rejected {
+			t.Errorf("Moderate(%q) rejected by %q, want %q", tt.text, res.Rejected, tt.rejected)
+			continue
+		}
+		if tt.rejected != "" {
+			continue
+		}
+		if res.Text != tt.want || !slices.Equal(res.Flags, tt.flags) {
+			t.Errorf("Moderate(%q) = %q flagged %v, want %q flagged %v", tt.text, res.Text, res.Flags, tt.want, tt.flags)
+		}
+	}
+}
+
+func TestModerateMasksBeforeLaterRules(t *testing.T) {
+	p, err := Compile(Config{Rules: []Rule{
+		{Name: "profanity", Action: ActionMask, Words: []string{"kerfuffle"}},
+		{Name: "strict", Action: ActionReject, Words: []string{"kerfuffle"}},
+	}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := p.Moderate("Kerfuffle!"); res.Rejected != "" || res.Text != "****!" {
+		t.Fatalf("got %+v, want the masked word out of reach of the reject rule", res)
+	}
+}
+
+func TestCompileRejectsInvalidRules(t *testing.T) {
+	tests := map[string]Rule{
+		"no name":           {Action: ActionMask, Words: []string{"a"}},
+		"unknown action":    {Name: "r", Action: "ban", Words: []string{"a"}},
+		"words and pattern": {Name: "r", Action: ActionMask, Words: []string{"a"}, Pattern: "a"},
+		"nothing to match":  {Name: "r", Action: ActionMask},
+		"phrase":            {Name: "r", Action: ActionMask, Words: []string{"two words"}},
+		"bad pattern":       {Name: "r", Action: ActionFlag, Pattern: "("},
+	}
+	for name, rule := range tests {
+		if _, err := Compile(Config{Rules: []Rule{rule}}, ""); err == nil {
+			t.Errorf("%s: compiled", name)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package moderation
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+)
+
+// Moderator holds the pipeline compiled from a rules file.
+// Reload swaps it atomically, requests in flight keep the previous one.
+type Moderator struct {
+	path    string
+	current atomic.Pointer[Pipeline]
+}
+
+// NewModerator compiles the rules file at path,
+// falling back to DefaultConfig when it does not exist
+func NewModerator(path string) (*Moderator, error) {
+	m := &Moderator{path: path}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Reload compiles the rules file again. On error the current rules stay in
+// place: only a missing rules file falls back to DefaultConfig, not a rules
+// file referring to a missing words file.
+func (m *Moderator) Reload() error {
+	var p *Pipeline
+	_, err := os.Stat(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		p, err = Compile(DefaultConfig, "")
+	} else if err == nil {
+		p, err = Load(m.path)
+	}
+	if err != nil {
+		return err
+	}
+	m.current.Store(p)
+	return nil
+}
+
+func (m *Moderator) Moderate(text string) Result {
+	return m.current.Load().Moderate(text)
+}
@@ -0,0 +1,50 @@
+package moderation
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeRules(t *testing.T, path, rules string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestModeratorDefaultsWithoutRulesFile(t *testing.T) {
+	m, err := NewModerator(filepath.Join(t.TempDir(), "moderation.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Moderate("what a kerfuffle").Text; got != "what a ****" {
+		t.Fatalf("got %q, want the default rules to mask", got)
+	}
+}
+
+func TestModeratorReloadKeepsRulesOnMissingWordsFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "moderation.yaml")
+	writeRules(t, filepath.Join(dir, "banned.txt"), "spam\n")
+	writeRules(t, path, "rules:\n  - name: banned\n    action: reject\n    words_file: banned.txt\n")
+	m, err := NewModerator(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Moderate("buy spam").Rejected != "banned" {
+		t.Fatal("the configured rule does not reject")
+	}
+
+	writeRules(t, path, "rules:\n  - name: banned\n    action: reject\n    words_file: baned.txt\n")
+	if err := m.Reload(); err == nil {
+		t.Fatal("reload with a missing words file succeeded")
+	}
+	if m.Moderate("buy spam").Rejected != "banned" {
+		t.Fatal("the failed reload dropped the configured rules")
+	}
+
+	if _, err := NewModerator(path); err == nil {
+		t.Fatal("a missing words file fell back to the default rules")
+	}
+}
@@ -0,0 +1,203 @@
+// Package search implements an in-memory inverted index for full-text
+// search over short documents such as chirps.
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// Tokenize splits text into lowercase terms made of letters and digits.
+// Everything else, including the asterisks of masked words, separates terms.
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Query is a parsed search: every term and every phrase must match
+type Query struct {
+	Terms   []string
+	Phrases [][]string
+}
+
+// ParseQuery reads double-quoted phrases and bare terms from text.
+// An unterminated quote runs until the end of the text.
+func ParseQuery(text string) Query {
+	q := Query{}
+	for i, part := range strings.Split(text, `"`) {
+		tokens := Tokenize(part)
+		// odd parts are between quotes
+		if i%2 == 1 && len(tokens) > 1 {
+			q.Phrases = append(q.Phrases, tokens)
+		} else {
+			q.Terms = append(q.Terms, tokens...)
+		}
+	}
+	return q
+}
+
+// IsEmpty reports whether the query has nothing to match
+func (q Query) IsEmpty() bool {
+	return len(q.Terms) == 0 && len(q.Phrases) == 0
+}
+
+// Result is a matching document and its relevance, higher is better
+type Result struct {
+	Id    int
+	Score float64
+}
+
+// Index maps terms to the documents and positions they appear at.
+// It is not safe for concurrent use.
+type Index struct {
+	postings map[string]map[int][]int
+	// docTerms lets Remove visit only the postings of a document
+	docTerms map[int][]string
+	docLen   map[int]int
+	totalLen int
+}
+
+func NewIndex() *Index {
+	return &Index{
+		postings: map[string]map[int][]int{},
+		docTerms: map[int][]string{},
+		docLen:   map[int]int{},
+	}
+}
+
+// Add indexes text under id, replacing what was indexed for it before
+func (idx *Index) Add(id int, text string) {
+	idx.Remove(id)
+	terms := Tokenize(text)
+	for pos, term := range terms {
+		docs, ok := idx.postings[term]
+		if !ok {
+			docs = map[int][]int{}
+			idx.postings[term] = docs
+		}
+		if _, seen := docs[id]; !seen {
+			idx.docTerms[id] = append(idx.docTerms[id], term)
+		}
+		docs[id] = append(docs[id], pos)
+	}
+	idx.docLen[id] = len(terms)
+	idx.totalLen += len(terms)
+}
+
+// Remove drops id from the index
+func (idx *Index) Remove(id int) {
+	length, ok := idx.docLen[id]
+	if !ok {
+		return
+	}
+	for _, term := range idx.docTerms[id] {
+		delete(idx.postings[term], id)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	delete(idx.docTerms, id)
+	delete(idx.docLen, id)
+	idx.totalLen -= length
+}
+
+// Search returns the documents matching q, most relevant first
+func (idx *Index) Search(q Query) []Result {
+	if q.IsEmpty() {
+		return []Result{}
+	}
+	terms := append([]string{}, q.Terms...)
+	for _, phrase := range q.Phrases {
+		terms = append(terms, phrase...)
+	}
+
+	// start from the rarest term to keep the candidate set small
+	sort.Slice(terms, func(i, j int) bool { return len(idx.postings[terms[i]]) < len(idx.postings[terms[j]]) })
+	candidates := []int{}
+	for id := range idx.postings[terms[0]] {
+		candidates = append(candidates, id)
+	}
+
+	results := []Result{}
+	for _, id := range candidates {
+		if !idx.matchesAll(id, terms) || !idx.matchesPhrases(id, q.Phrases) {
+			continue
+		}
+		results = append(results, Result{Id: id, Score: idx.score(id, terms)})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score == results[j].Score {
+			return results[i].Id > results[j].Id
+		}
+		return results[i].Score > results[j].Score
+	})
+	return results
+}
+
+func (idx *Index) matchesAll(id int, terms []string) bool {
+	for _, term := range terms {
+		if _, found := idx.postings[term][id]; !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (idx *Index) matchesPhrases(id int, phrases [][]string) bool {
+	for _, phrase := range phrases {
+		if !idx.matchesPhrase(id, phrase) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesPhrase checks that the phrase terms appear at consecutive positions
+func (idx *Index) matchesPhrase(id int, phrase []string) bool {
+	for _, start := range idx.postings[phrase[0]][id] {
+		matched := true
+		for offset, term := range phrase[1:] {
+			if !containsInt(idx.postings[term][id], start+offset+1) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// score computes the BM25 relevance of document id for terms
+func (idx *Index) score(id int, terms []string) float64 {
+	n := float64(len(idx.docLen))
+	avgLen := float64(idx.totalLen) / n
+	docLen := float64(idx.docLen[id])
+	score := 0.0
+	for _, term := range terms {
+		df := float64(len(idx.postings[term]))
+		tf := float64(len(idx.postings[term][id]))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		score += idf * tf * (k1 + 1) / (tf + k1*(1-b+b*docLen/avgLen))
+	}
+	return score
+}
+
+func containsInt(values []int, v int) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
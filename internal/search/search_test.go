@@ -0,0 +1,128 @@
+package search
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Hello, World!", []string{"hello", "world"}},
+		{"what a ****", []string{"what", "a"}},
+		{"#golang rocks @ada", []string{"golang", "rocks", "ada"}},
+		{"Café 2024", []string{"café", "2024"}},
+		{"  ", nil},
+	}
+	for _, tt := range tests {
+		if got := Tokenize(tt.text); !slices.Equal(got, tt.want) {
+			t.Errorf("Tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		text string
+		want Query
+	}{
+		{"go rocks", Query{Terms: []string{"go", "rocks"}}},
+		{`"go rocks" fast`, Query{Terms: []string{"fast"}, Phrases: [][]string{{"go", "rocks"}}}},
+		// a quoted single word is a term
+		{`"go"`, Query{Terms: []string{"go"}}},
+		{`fast "go rocks`, Query{Terms: []string{"fast"}, Phrases: [][]string{{"go", "rocks"}}}},
+		{`""`, Query{}},
+	}
+	for _, tt := range tests {
+		got := ParseQuery(tt.text)
+		if !slices.Equal(got.Terms, tt.want.Terms) || !slices.EqualFunc(got.Phrases, tt.want.Phrases, slices.Equal) {
+			t.Errorf("ParseQuery(%q) = %+v, want %+v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func ids(results []Result) []int {
+	ids := []int{}
+	for _, r := range results {
+		ids = append(ids, r.Id)
+	}
+	return ids
+}
+
+func TestSearch(t *testing.T) {
+	idx := NewIndex()
+	docs := map[int]string{
+		1: "the quick brown fox",
+		2: "fox fox fox",
+		3: "brown quick fox jumps over the lazy dog and keeps running far away",
+		4: "what a ****",
+		5: "nothing to see",
+	}
+	for id, text := range docs {
+		idx.Add(id, text)
+	}
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		// more occurrences and shorter documents rank higher
+		{"fox", []int{2, 1, 3}},
+		{"quick fox", []int{1, 3}},
+		{`"quick brown"`, []int{1}},
+		{`"brown quick" fox`, []int{3}},
+		{`"fox quick"`, []int{}},
+		{"missing", []int{}},
+		{"kerfuffle", []int{}},
+		{"****", []int{}},
+		{"", []int{}},
+	}
+	for _, tt := range tests {
+		if got := ids(idx.Search(ParseQuery(tt.query))); !slices.Equal(got, tt.want) {
+			t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSearchTiesNewestFirst(t *testing.T) {
+	idx := NewIndex()
+	idx.Add(1, "hello world")
+	idx.Add(2, "hello world")
+	if got := ids(idx.Search(ParseQuery("hello"))); !slices.Equal(got, []int{2, 1}) {
+		t.Fatalf("got %v, want the newest first", got)
+	}
+}
+
+func TestRemoveAndUpdate(t *testing.T) {
+	idx := NewIndex()
+	idx.Add(1, "hello world")
+	idx.Add(2, "hello there")
+
+	idx.Add(1, "goodbye world")
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{"hello", []int{2}},
+		{"goodbye", []int{1}},
+		{"world", []int{1}},
+	}
+	for _, tt := range tests {
+		if got := ids(idx.Search(ParseQuery(tt.query))); !slices.Equal(got, tt.want) {
+			t.Errorf("after update, Search(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	idx.Remove(1)
+	idx.Remove(1)
+	if got := ids(idx.Search(ParseQuery("world"))); len(got) != 0 {
+		t.Errorf("after remove, got %v", got)
+	}
+	if _, found := idx.postings["goodbye"]; found {
+		t.Error("the postings of the removed document were kept")
+	}
+	if idx.totalLen != 2 || len(idx.docLen) != 1 {
+		t.Errorf("got total length %d over %d documents, want 2 over 1", idx.totalLen, len(idx.docLen))
+	}
+}